@@ -0,0 +1,50 @@
+// Command rotatekeys adds a new JWT signing key to the key directory and
+// removes keys that no live token can still be signed with.
+//
+//	go run ./cmd/rotatekeys -alg EdDSA -retain 192h
+//
+// Running servers publish the new key on their next reload and start signing
+// with it after keystore.KEY_ACTIVATION_DELAY.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"golang-restaurant-management/keystore"
+
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	godotenv.Load()
+
+	dir := flag.String("dir", os.Getenv("JWT_KEY_DIR"), "key directory, defaults to JWT_KEY_DIR")
+	alg := flag.String("alg", keystore.ALG_RS256, "algorithm of the new key, RS256 or EdDSA")
+	// Refresh tokens live for 7 days, keep one more day of margin.
+	retain := flag.Duration("retain", 192*time.Hour, "delete keys that stopped signing longer ago than this, at least 168h, 0 keeps all keys")
+	flag.Parse()
+
+	if *dir == "" {
+		log.Fatal("no key directory, set JWT_KEY_DIR or pass -dir")
+	}
+
+	kid, err := keystore.GenerateKey(*dir, *alg)
+	if err != nil {
+		log.Fatalf("Error generating key: %v", err)
+	}
+	fmt.Printf("added signing key %s\n", kid)
+
+	if *retain > 0 {
+		removed, err := keystore.Prune(*dir, *retain)
+		if err != nil {
+			log.Fatalf("Error removing old keys: %v", err)
+		}
+		for _, oldKid := range removed {
+			fmt.Printf("removed key %s\n", oldKid)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package controller
+
+import (
+	"context"
+	"golang-restaurant-management/database"
+	helper "golang-restaurant-management/helpers"
+	"golang-restaurant-management/models"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var apiKeyCollection *mongo.Collection = database.OpenCollection(database.Client, "api_keys")
+
+func GetApiKeys() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		opts := options.Find().SetSort(bson.M{"created_at": -1})
+		result, err := apiKeyCollection.Find(ctx, bson.M{}, opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing api keys"})
+			return
+		}
+
+		allApiKeys := []models.ApiKey{}
+		if err = result.All(ctx, &allApiKeys); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing api keys"})
+			return
+		}
+		c.JSON(http.StatusOK, allApiKeys)
+	}
+}
+
+func GetApiKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var apiKey models.ApiKey
+
+		err := apiKeyCollection.FindOne(ctx, bson.M{"api_key_id": c.Param("api_key_id")}).Decode(&apiKey)
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "api key not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching the api key"})
+			return
+		}
+		c.JSON(http.StatusOK, apiKey)
+	}
+}
+
+// CreateApiKey returns the plain text key in the response. It is not stored
+// and cannot be shown again.
+func CreateApiKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var apiKey models.ApiKey
+
+		if err := c.BindJSON(&apiKey); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		validationErr := validate.Struct(apiKey)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+		if !validApiKeyScopes(c, apiKey.Scopes) || !validApiKeyExpiry(c, apiKey.Expires_at) {
+			return
+		}
+
+		key, keyHash, keyPrefix, err := helper.GenerateAPIKey()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while generating the api key"})
+			return
+		}
+
+		apiKey.Key_hash = keyHash
+		apiKey.Key_prefix = keyPrefix
+		apiKey.Last_used_at = nil
+		apiKey.Created_by = c.GetString("uid")
+		apiKey.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		apiKey.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		apiKey.ID = primitive.NewObjectID()
+		apiKey.Api_key_id = apiKey.ID.Hex()
+
+		_, insertErr := apiKeyCollection.InsertOne(ctx, apiKey)
+		if insertErr != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "api key was not created"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"api_key": key, "details": apiKey})
+	}
+}
+
+// UpdateApiKey changes the name, role, scopes, terminal or expiry of a key. The key
+// itself stays the same, delete it and create a new one to rotate it.
+func UpdateApiKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var apiKey models.ApiKey
+
+		if err := c.BindJSON(&apiKey); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if apiKey.Name != nil {
+			if err := validate.Var(*apiKey.Name, "min=2,max=100"); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		if apiKey.Role != nil {
+			if err := validate.Var(*apiKey.Role, "eq=MANAGER|eq=WAITER|eq=KITCHEN|eq=CASHIER"); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		if apiKey.Terminal_id != nil {
+			if err := validate.Var(*apiKey.Terminal_id, "min=1,max=64"); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		if apiKey.Scopes != nil && len(apiKey.Scopes) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "an api key needs at least one scope"})
+			return
+		}
+		if !validApiKeyScopes(c, apiKey.Scopes) || !validApiKeyExpiry(c, apiKey.Expires_at) {
+			return
+		}
+
+		var updateObj primitive.D
+
+		if apiKey.Name != nil {
+			updateObj = append(updateObj, bson.E{Key: "name", Value: apiKey.Name})
+		}
+
+		if apiKey.Role != nil {
+			updateObj = append(updateObj, bson.E{Key: "role", Value: apiKey.Role})
+		}
+
+		if apiKey.Scopes != nil {
+			updateObj = append(updateObj, bson.E{Key: "scopes", Value: apiKey.Scopes})
+		}
+
+		if apiKey.Terminal_id != nil {
+			updateObj = append(updateObj, bson.E{Key: "terminal_id", Value: apiKey.Terminal_id})
+		}
+
+		if apiKey.Expires_at != nil {
+			updateObj = append(updateObj, bson.E{Key: "expires_at", Value: apiKey.Expires_at})
+		}
+
+		apiKey.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		updateObj = append(updateObj, bson.E{Key: "updated_at", Value: apiKey.Updated_at})
+
+		result, err := apiKeyCollection.UpdateOne(
+			ctx,
+			bson.M{"api_key_id": c.Param("api_key_id")},
+			bson.D{
+				{Key: "$set", Value: updateObj},
+			},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "api key update failed"})
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "api key not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// DeleteApiKey revokes the key, requests using it are rejected right away.
+func DeleteApiKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		result, err := apiKeyCollection.DeleteOne(ctx, bson.M{"api_key_id": c.Param("api_key_id")})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "api key was not deleted"})
+			return
+		}
+		if result.DeletedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "api key not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Api key deleted successfully", "DeletedCount": result.DeletedCount})
+	}
+}
+
+func validApiKeyScopes(c *gin.Context, scopes []string) bool {
+	for _, scope := range scopes {
+		if !helper.ValidAPIKeyScope(scope) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid scope " + scope + ", use \"*\" or \"<resource>:<read|write>\""})
+			return false
+		}
+	}
+	return true
+}
+
+func validApiKeyExpiry(c *gin.Context, expiresAt *time.Time) bool {
+	if expiresAt != nil && expiresAt.Before(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at must be in the future"})
+		return false
+	}
+	return true
+}
@@ -102,6 +102,15 @@ func CreateFood() gin.HandlerFunc {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
 			return
 		}
+		if !checkStation(ctx, c, food.Station_id) {
+			return
+		}
+		modifierGroups, msg := prepareModifierGroups(food.Modifier_groups)
+		if msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+		food.Modifier_groups = modifierGroups
 		food.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		food.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		food.ID = primitive.NewObjectID()
@@ -129,6 +138,50 @@ func toFixed(num float64, precision int) float64 {
 	return float64(round(num*output)) / output
 }
 
+// prepareModifierGroups checks the selection limits of modifier groups and
+// gives new groups and options their ids. Ids sent by the client are kept so
+// updating a food does not break the modifiers of items already ordered.
+func prepareModifierGroups(groups []models.ModifierGroup) ([]models.ModifierGroup, string) {
+	prepared := []models.ModifierGroup{}
+	groupIds := map[string]bool{}
+
+	for _, group := range groups {
+		if group.Max_selections > 0 && group.Max_selections < group.Min_selections {
+			return nil, fmt.Sprintf("modifier group %s allows fewer selections than it requires", group.Name)
+		}
+		if group.Max_selections > len(group.Options) {
+			return nil, fmt.Sprintf("modifier group %s allows more selections than it has options", group.Name)
+		}
+
+		if group.Group_id == "" {
+			group.Group_id = primitive.NewObjectID().Hex()
+		}
+		if groupIds[group.Group_id] {
+			return nil, fmt.Sprintf("modifier group id %s is used twice", group.Group_id)
+		}
+		groupIds[group.Group_id] = true
+
+		optionIds := map[string]bool{}
+		groupOptions := []models.ModifierOption{}
+		for _, option := range group.Options {
+			if option.Option_id == "" {
+				option.Option_id = primitive.NewObjectID().Hex()
+			}
+			if optionIds[option.Option_id] {
+				return nil, fmt.Sprintf("modifier option id %s is used twice in %s", option.Option_id, group.Name)
+			}
+			optionIds[option.Option_id] = true
+			option.Price_delta = toFixed(option.Price_delta, 2)
+			groupOptions = append(groupOptions, option)
+		}
+		group.Options = groupOptions
+
+		prepared = append(prepared, group)
+	}
+
+	return prepared, ""
+}
+
 func UpdateFood() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
@@ -167,6 +220,26 @@ func UpdateFood() gin.HandlerFunc {
 			// updateObj = append(updateObj, bson.E{"menu", food.Price})
 		}
 
+		if food.Station_id != nil {
+			if !checkStation(ctx, c, food.Station_id) {
+				return
+			}
+			updateObj = append(updateObj, bson.E{Key: "station_id", Value: food.Station_id})
+		}
+
+		if food.Modifier_groups != nil {
+			if validationErr := validate.Var(food.Modifier_groups, "dive"); validationErr != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+				return
+			}
+			modifierGroups, msg := prepareModifierGroups(food.Modifier_groups)
+			if msg != "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+				return
+			}
+			updateObj = append(updateObj, bson.E{Key: "modifier_groups", Value: modifierGroups})
+		}
+
 		food.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		updateObj = append(updateObj, bson.E{"updated_at", food.Updated_at})
 
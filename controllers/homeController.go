@@ -1,6 +1,7 @@
 package controller
 
 import (
+	"golang-restaurant-management/keystore"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -19,3 +20,12 @@ func Documentation() gin.HandlerFunc {
 		c.HTML(http.StatusOK, "documentation.html", gin.H{})
 	}
 }
+
+// JWKS publishes the public keys tokens are signed with, so other services
+// can verify them without sharing a secret.
+func JWKS() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Cache-Control", "public, max-age=300")
+		c.JSON(http.StatusOK, keystore.PublicKeys())
+	}
+}
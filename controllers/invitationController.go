@@ -0,0 +1,270 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"golang-restaurant-management/database"
+	helper "golang-restaurant-management/helpers"
+	"golang-restaurant-management/models"
+	"golang-restaurant-management/tasks"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var invitationCollection *mongo.Collection = database.OpenCollection(database.Client, "invitations")
+
+func GetInvitations() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		filter := bson.M{}
+		if status := c.Query("status"); status != "" {
+			filter["status"] = status
+		}
+
+		opts := options.Find().SetSort(bson.M{"created_at": -1})
+		result, err := invitationCollection.Find(ctx, filter, opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing invitations"})
+			return
+		}
+
+		allInvitations := []models.Invitation{}
+		if err = result.All(ctx, &allInvitations); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing invitations"})
+			return
+		}
+		c.JSON(http.StatusOK, allInvitations)
+	}
+}
+
+// CreateInvitation invites a new member of staff by email. Inviting an email
+// that already has a pending invitation sends it again with a fresh link.
+func CreateInvitation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var invitation models.Invitation
+
+		if err := c.BindJSON(&invitation); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		validationErr := validate.Struct(invitation)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		if !isEmailAvailable(ctx, c, *invitation.Email) {
+			return
+		}
+
+		var pending models.Invitation
+		err := invitationCollection.FindOne(ctx, bson.M{"email": invitation.Email, "status": models.INVITATION_PENDING}).Decode(&pending)
+		if err == nil {
+			invitation.ID = pending.ID
+			invitation.Created_at = pending.Created_at
+		} else if err == mongo.ErrNoDocuments {
+			invitation.ID = primitive.NewObjectID()
+			invitation.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while checking for pending invitations"})
+			return
+		}
+
+		invitation.Invitation_id = invitation.ID.Hex()
+		token, claims, err := helper.GenerateInvitationToken(invitation.Invitation_id, *invitation.Email, *invitation.Role)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate the invitation"})
+			return
+		}
+
+		invitation.Status = models.INVITATION_PENDING
+		invitation.Token_id = claims.ID
+		invitation.Invited_by = c.GetString("uid")
+		invitation.Expires_at = claims.ExpiresAt.Time
+		invitation.Accepted_at = nil
+		invitation.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+		upsert := true
+		opt := options.ReplaceOptions{
+			Upsert: &upsert,
+		}
+		_, err = invitationCollection.ReplaceOne(ctx, bson.M{"_id": invitation.ID}, invitation, &opt)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "invitation was not created"})
+			return
+		}
+
+		err = tasks.QueueInvitationEmail(*invitation.Email, *invitation.Role, invitationLink(token))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue invitation email"})
+			return
+		}
+
+		helper.RecordAudit(c.GetString("uid"), models.AUDIT_INVITATION_SENT, "invitation", invitation.Invitation_id, c.ClientIP(), map[string]interface{}{"role": *invitation.Role})
+
+		c.JSON(http.StatusOK, invitation)
+	}
+}
+
+// RevokeInvitation makes the link of a pending invitation stop working.
+func RevokeInvitation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		result, err := invitationCollection.UpdateOne(
+			ctx,
+			bson.M{"invitation_id": c.Param("invitation_id"), "status": models.INVITATION_PENDING},
+			bson.M{"$set": bson.M{"status": models.INVITATION_REVOKED, "updated_at": time.Now()}},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "invitation was not revoked"})
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "no pending invitation found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Invitation revoked successfully"})
+	}
+}
+
+var errInvitationUsed = errors.New("the invitation was already used")
+
+// AcceptInvitation creates the account of an invited member of staff with the
+// role the admin picked. Following the emailed link proves the address, so
+// the account is verified right away.
+func AcceptInvitation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var acceptData struct {
+			Token      string  `json:"token" binding:"required"`
+			Password   string  `json:"password" binding:"required,min=6"`
+			First_name *string `json:"first_name" validate:"omitempty,min=2,max=100"`
+			Last_name  *string `json:"last_name" validate:"omitempty,min=2,max=100"`
+			Phone      *string `json:"phone" validate:"required"`
+		}
+
+		if err := c.BindJSON(&acceptData); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		validationErr := validate.Struct(acceptData)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		claims, msg := helper.ValidateToken(acceptData.Token)
+		if msg != "" || claims.Token_type != helper.INVITATION_TOKEN {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "the invitation link is invalid or has expired"})
+			return
+		}
+
+		var invitation models.Invitation
+		err := invitationCollection.FindOne(ctx, bson.M{"invitation_id": claims.Subject}).Decode(&invitation)
+		if err != nil || invitation.Status != models.INVITATION_PENDING || invitation.Token_id != claims.ID {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "the invitation link is invalid or has expired"})
+			return
+		}
+
+		if !isEmailAvailable(ctx, c, *invitation.Email) {
+			return
+		}
+
+		user := models.User{
+			First_name: invitation.First_name,
+			Last_name:  invitation.Last_name,
+			Email:      invitation.Email,
+			Phone:      acceptData.Phone,
+			Role:       invitation.Role,
+		}
+		if acceptData.First_name != nil {
+			user.First_name = acceptData.First_name
+		}
+		if acceptData.Last_name != nil {
+			user.Last_name = acceptData.Last_name
+		}
+		if user.First_name == nil || user.Last_name == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "first_name and last_name are required"})
+			return
+		}
+
+		password := HashPassword(acceptData.Password)
+		user.Password = &password
+		user.Is_Verified = true
+		user.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		user.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		user.ID = primitive.NewObjectID()
+		user.User_id = user.ID.Hex()
+
+		// Claiming the invitation and creating the user happen together, so
+		// the same link cannot create two users and a failed insert leaves
+		// the invitation pending.
+		acceptedAt := time.Now()
+		err = database.RunTransaction(ctx, func(sessionCtx mongo.SessionContext) error {
+			result, err := invitationCollection.UpdateOne(
+				sessionCtx,
+				bson.M{"invitation_id": invitation.Invitation_id, "status": models.INVITATION_PENDING, "token_id": claims.ID},
+				bson.M{"$set": bson.M{"status": models.INVITATION_ACCEPTED, "accepted_at": acceptedAt, "updated_at": acceptedAt}},
+			)
+			if err != nil {
+				return err
+			}
+			if result.ModifiedCount == 0 {
+				return errInvitationUsed
+			}
+
+			_, err = userCollection.InsertOne(sessionCtx, user)
+			return err
+		})
+		if err == errInvitationUsed {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "the invitation link is invalid or has expired"})
+			return
+		}
+		if mongo.IsDuplicateKeyError(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "this email already exists"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "User item was not created"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Invitation accepted, you can now log in", "user_id": user.User_id})
+	}
+}
+
+// invitationLink builds the link sent in the invitation email. INVITATION_URL
+// points at the page of the frontend that accepts invitations.
+func invitationLink(token string) string {
+	base := os.Getenv("INVITATION_URL")
+	if base == "" {
+		base = "http://localhost/invitations/accept"
+	}
+	return base + "?token=" + url.QueryEscape(token)
+}
+
+// isSignupOpen reports whether anyone may sign up. Set OPEN_SIGNUP=false to
+// only let staff in through invitations.
+func isSignupOpen() bool {
+	return os.Getenv("OPEN_SIGNUP") != "false"
+}
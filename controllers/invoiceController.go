@@ -22,8 +22,11 @@ type InvoiceViewFormat struct {
 	Order_id         string
 	Payment_status   *string
 	Payment_due      interface{}
+	Order_type       interface{}
+	Delivery_fee     interface{}
 	Table_number     interface{}
 	Payment_due_date time.Time
+	Superseded_by    *string
 	Order_details    interface{}
 }
 
@@ -31,23 +34,30 @@ var invoiceCollection *mongo.Collection = database.OpenCollection(database.Clien
 
 func GetInvoices() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		typeFilter, ok := orderTypeFilter(c)
+		if !ok {
+			return
+		}
 
-		result, err := invoiceCollection.Find(context.TODO(), bson.M{})
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
+
+		filter := bson.M{}
+		if err := filterByOrders(ctx, filter, typeFilter); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing invoice items"})
+			return
+		}
+
+		result, err := invoiceCollection.Find(context.TODO(), filter)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing invoice items"})
 		}
 
-		var allInvoices []bson.M
+		allInvoices := []bson.M{}
 		if err = result.All(ctx, &allInvoices); err != nil {
 			log.Fatal(err)
 		}
-		if allInvoices == nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "No invoice items found"})
-		} else {
-			c.JSON(http.StatusOK, allInvoices)
-		}
+		c.JSON(http.StatusOK, allInvoices)
 	}
 }
 
@@ -66,7 +76,7 @@ func GetInvoice() gin.HandlerFunc {
 
 		var invoiceView InvoiceViewFormat
 
-		allOrderItems, err := ItemsByOrder(invoice.Order_id)
+		allOrderItems, err := ItemsByOrder(invoice.Order_id, nil)
 		invoiceView.Order_id = invoice.Order_id
 		invoiceView.Payment_due_date = invoice.Payment_due_date
 
@@ -77,9 +87,16 @@ func GetInvoice() gin.HandlerFunc {
 
 		invoiceView.Invoice_id = invoice.Invoice_id
 		invoiceView.Payment_status = *&invoice.Payment_status
-		invoiceView.Payment_due = allOrderItems[0]["payment_due"]
-		invoiceView.Table_number = allOrderItems[0]["table_number"]
-		invoiceView.Order_details = allOrderItems[0]["order_items"]
+		invoiceView.Superseded_by = invoice.Superseded_by
+
+		// A superseded invoice lost its items to the order it was merged into.
+		if len(allOrderItems) > 0 {
+			invoiceView.Payment_due = allOrderItems[0]["payment_due"]
+			invoiceView.Order_type = allOrderItems[0]["order_type"]
+			invoiceView.Delivery_fee = allOrderItems[0]["delivery_fee"]
+			invoiceView.Table_number = allOrderItems[0]["table_number"]
+			invoiceView.Order_details = allOrderItems[0]["order_items"]
+		}
 
 		c.JSON(http.StatusOK, invoiceView)
 	}
@@ -104,6 +121,12 @@ func CreateInvoice() gin.HandlerFunc {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
 			return
 		}
+
+		if orderStatus(order) != models.ORDER_SERVED {
+			c.JSON(http.StatusConflict, gin.H{"error": "only served orders can be invoiced"})
+			return
+		}
+
 		status := "PENDING"
 		if invoice.Payment_status == nil {
 			invoice.Payment_status = &status
@@ -145,6 +168,26 @@ func UpdateInvoice() gin.HandlerFunc {
 			return
 		}
 
+		if invoice.Payment_method != nil && validate.Var(*invoice.Payment_method, "eq=CARD|eq=CASH|eq=") != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "payment_method must be CARD or CASH"})
+			return
+		}
+		if invoice.Payment_status != nil && validate.Var(*invoice.Payment_status, "eq=PENDING|eq=PAID") != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "payment_status must be PENDING or PAID"})
+			return
+		}
+
+		// Invoices voided by a merge stay void.
+		voided, err := invoiceCollection.CountDocuments(ctx, bson.M{"invoice_id": invoiceId, "payment_status": models.INVOICE_VOID})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "invoice item update failed"})
+			return
+		}
+		if voided > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "the invoice was voided"})
+			return
+		}
+
 		filter := bson.M{"invoice_id": invoiceId}
 
 		var updateObj primitive.D
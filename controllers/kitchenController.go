@@ -0,0 +1,326 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	helper "golang-restaurant-management/helpers"
+	"golang-restaurant-management/models"
+	"golang-restaurant-management/tasks"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	KITCHEN_REPLAY_BATCH    = 500
+	KITCHEN_HEARTBEAT       = 15 * time.Second
+	KITCHEN_SOCKET_DEADLINE = 10 * time.Second
+	// How often an open feed checks that its token or api key still holds.
+	KITCHEN_REVALIDATE = 30 * time.Second
+)
+
+var errKitchenCredentialInvalid = errors.New("the credential of the feed is no longer valid")
+
+var kitchenUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// CreateKitchenFeedTicket hands out a single use ticket for opening the feed
+// from a browser, which cannot send the token header with EventSource and
+// WebSocket requests. Pass it as ?ticket= within KITCHEN_TICKET_TTL.
+func CreateKitchenFeedTicket() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ticket, err := randomURLToken()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create a feed ticket"})
+			return
+		}
+
+		err = tasks.StoreKitchenTicket(ticket, tasks.KitchenCredential{
+			Token:   c.Request.Header.Get("token"),
+			Api_key: c.Request.Header.Get("X-API-Key"),
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create a feed ticket"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"ticket":     ticket,
+			"expires_at": time.Now().Add(tasks.KITCHEN_TICKET_TTL),
+		})
+	}
+}
+
+// KitchenFeed streams kitchen events as server-sent events. A display that
+// reconnects resumes after the Last-Event-ID header or the cursor query,
+// ?station=<station_id>,... limits the item events to those stations. The
+// feed ends with a revoked event once its credential stops being valid.
+func KitchenFeed() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cursor := c.Query("cursor")
+		if cursor == "" {
+			cursor = c.GetHeader("Last-Event-ID")
+		}
+		if cursor != "" && !tasks.ValidKitchenCursor(cursor) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid cursor"})
+			return
+		}
+
+		ctx := c.Request.Context()
+
+		pubsub, err := tasks.SubscribeKitchenEvents(ctx)
+		if err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "the kitchen feed is not available"})
+			return
+		}
+		defer pubsub.Close()
+
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+		c.Header("X-Accel-Buffering", "no")
+		c.Status(http.StatusOK)
+		c.Writer.Flush()
+
+		send := func(event tasks.KitchenEvent) error {
+			payload, err := json.Marshal(event)
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(c.Writer, "id: %s\nevent: %s\ndata: %s\n\n", event.Id, event.Type, payload); err != nil {
+				return err
+			}
+			c.Writer.Flush()
+			return nil
+		}
+		ping := func() error {
+			if _, err := fmt.Fprint(c.Writer, ": ping\n\n"); err != nil {
+				return err
+			}
+			c.Writer.Flush()
+			return nil
+		}
+
+		err = streamKitchenEvents(ctx, pubsub, cursor, newKitchenFeedFilter(c), kitchenCredentialCheck(c), send, ping)
+		if err == errKitchenCredentialInvalid {
+			fmt.Fprintf(c.Writer, "event: revoked\ndata: {\"error\":%q}\n\n", err.Error())
+			c.Writer.Flush()
+			return
+		}
+		if err != nil {
+			log.Printf("kitchen feed ended: %v", err)
+		}
+	}
+}
+
+// KitchenFeedSocket streams the same events over a WebSocket, one JSON event
+// per message. Reconnecting displays pass the id of the last event they got
+// as the cursor query. The socket is closed with a policy violation once its
+// credential stops being valid.
+func KitchenFeedSocket() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cursor := c.Query("cursor")
+		if cursor != "" && !tasks.ValidKitchenCursor(cursor) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid cursor"})
+			return
+		}
+
+		ctx, cancel := context.WithCancel(c.Request.Context())
+		defer cancel()
+
+		pubsub, err := tasks.SubscribeKitchenEvents(ctx)
+		if err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "the kitchen feed is not available"})
+			return
+		}
+		defer pubsub.Close()
+
+		conn, err := kitchenUpgrader.Upgrade(c.Writer, c.Request, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		// The display never sends anything, reading only notices when it goes
+		// away.
+		go func() {
+			defer cancel()
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+
+		send := func(event tasks.KitchenEvent) error {
+			conn.SetWriteDeadline(time.Now().Add(KITCHEN_SOCKET_DEADLINE))
+			return conn.WriteJSON(event)
+		}
+		ping := func() error {
+			return conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(KITCHEN_SOCKET_DEADLINE))
+		}
+
+		err = streamKitchenEvents(ctx, pubsub, cursor, newKitchenFeedFilter(c), kitchenCredentialCheck(c), send, ping)
+		if err == errKitchenCredentialInvalid {
+			message := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, err.Error())
+			conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(KITCHEN_SOCKET_DEADLINE))
+			return
+		}
+		if err != nil {
+			log.Printf("kitchen socket ended: %v", err)
+		}
+	}
+}
+
+// streamKitchenEvents replays the events after cursor and then forwards live
+// ones until ctx ends or check fails, which it runs every KITCHEN_REVALIDATE.
+// The subscription is taken before the replay, so live events that were also
+// replayed are skipped by their id.
+func streamKitchenEvents(ctx context.Context, pubsub *redis.PubSub, cursor string, filter kitchenFeedFilter, check func() error, send func(tasks.KitchenEvent) error, ping func() error) error {
+	last := cursor
+
+	for last != "" {
+		events, err := tasks.KitchenEventsSince(ctx, last, KITCHEN_REPLAY_BATCH)
+		if err != nil {
+			return err
+		}
+		for _, event := range events {
+			last = event.Id
+			if !filter.matches(event) {
+				continue
+			}
+			if err := send(event); err != nil {
+				return err
+			}
+		}
+		if len(events) < KITCHEN_REPLAY_BATCH {
+			break
+		}
+	}
+
+	heartbeat := time.NewTicker(KITCHEN_HEARTBEAT)
+	defer heartbeat.Stop()
+	revalidate := time.NewTicker(KITCHEN_REVALIDATE)
+	defer revalidate.Stop()
+
+	messages := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-heartbeat.C:
+			if err := ping(); err != nil {
+				return err
+			}
+		case <-revalidate.C:
+			if err := check(); err != nil {
+				return err
+			}
+		case message, ok := <-messages:
+			if !ok {
+				return nil
+			}
+
+			var event tasks.KitchenEvent
+			if err := json.Unmarshal([]byte(message.Payload), &event); err != nil {
+				continue
+			}
+			if last != "" && !tasks.KitchenEventAfter(event.Id, last) {
+				continue
+			}
+			last = event.Id
+			if !filter.matches(event) {
+				continue
+			}
+			if err := send(event); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// kitchenCredentialCheck returns a check that fails with
+// errKitchenCredentialInvalid once the token or api key the feed was opened
+// with is no longer valid: it expired or was revoked, the user was logged out
+// everywhere or deactivated, or the api key was deleted.
+func kitchenCredentialCheck(c *gin.Context) func() error {
+	value, _ := c.Get("kitchen_credential")
+	credential, _ := value.(tasks.KitchenCredential)
+
+	return func() error {
+		msg := ""
+		if credential.Api_key != "" {
+			_, msg = helper.ValidateAPIKey(credential.Api_key)
+		} else {
+			_, msg = helper.ValidateToken(credential.Token)
+		}
+		if msg != "" {
+			return errKitchenCredentialInvalid
+		}
+		return nil
+	}
+}
+
+// kitchenFeedFilter picks the events a display gets: those of its stations
+// and the notes its user may see.
+type kitchenFeedFilter struct {
+	stations map[string]bool
+	role     string
+}
+
+func newKitchenFeedFilter(c *gin.Context) kitchenFeedFilter {
+	filter := kitchenFeedFilter{stations: map[string]bool{}, role: c.GetString("role")}
+	for _, station := range strings.Split(c.Query("station"), ",") {
+		station = strings.TrimSpace(station)
+		if station != "" {
+			filter.stations[station] = true
+		}
+	}
+	return filter
+}
+
+// matches lets events without a station, like order status changes, through
+// to every display.
+func (filter kitchenFeedFilter) matches(event tasks.KitchenEvent) bool {
+	if event.Visibility != "" && !canSeeNote(filter.role, event.Visibility) {
+		return false
+	}
+	if len(filter.stations) == 0 || event.Station == "" {
+		return true
+	}
+	return filter.stations[event.Station]
+}
+
+// publishKitchenEvent only logs failures, a display that misses the live
+// event still gets it on its next replay if it was stored.
+func publishKitchenEvent(event tasks.KitchenEvent) {
+	if err := tasks.PublishKitchenEvent(event); err != nil {
+		log.Printf("failed to publish kitchen event %s for order %s: %v", event.Type, event.Order_id, err)
+	}
+}
+
+func kitchenTicket(orderItem models.OrderItem, food models.Food) gin.H {
+	return gin.H{
+		"order_item_id": orderItem.Order_item_id,
+		"food_id":       orderItem.Food_id,
+		"food_name":     food.Name,
+		"quantity":      orderItem.Quantity,
+		"modifiers":     orderItem.Modifiers,
+	}
+}
+
+func kitchenStationOf(orderItem models.OrderItem) string {
+	if orderItem.Station_id == nil {
+		return ""
+	}
+	return *orderItem.Station_id
+}
@@ -73,6 +73,10 @@ func CreateMenu() gin.HandlerFunc {
 			return
 		}
 
+		if !checkStation(ctx, c, menu.Station_id) {
+			return
+		}
+
 		menu.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		menu.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		menu.ID = primitive.NewObjectID()
@@ -114,6 +118,12 @@ func UpdateMenu() gin.HandlerFunc {
 		if menu.Category != "" {
 			updateObj = append(updateObj, bson.E{"category", menu.Category})
 		}
+		if menu.Station_id != nil {
+			if !checkStation(ctx, c, menu.Station_id) {
+				return
+			}
+			updateObj = append(updateObj, bson.E{Key: "station_id", Value: menu.Station_id})
+		}
 
 		updateObj = append(updateObj, bson.E{"updated_at", time.Now()})
 
@@ -0,0 +1,353 @@
+package controller
+
+import (
+	"context"
+	"golang-restaurant-management/database"
+	"golang-restaurant-management/models"
+	"golang-restaurant-management/tasks"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var noteCollection *mongo.Collection = database.OpenCollection(database.Client, "note")
+
+// GetNotes lists the notes the caller may see, optionally only those of one
+// target with ?target_type=ORDER&target_id=<order_id>.
+func GetNotes() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		filter := bson.M{"visibility": bson.M{"$in": noteVisibilities(c.GetString("role"))}}
+		if targetType := c.Query("target_type"); targetType != "" {
+			filter["target_type"] = targetType
+		}
+		if targetId := c.Query("target_id"); targetId != "" {
+			filter["target_id"] = targetId
+		}
+
+		cursor, err := noteCollection.Find(ctx, filter, options.Find().SetSort(bson.M{"created_at": 1}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing notes"})
+			return
+		}
+
+		notes := []models.Note{}
+		if err = cursor.All(ctx, &notes); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing notes"})
+			return
+		}
+
+		c.JSON(http.StatusOK, notes)
+	}
+}
+
+func GetNote() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		note, ok := findVisibleNote(ctx, c)
+		if !ok {
+			return
+		}
+
+		c.JSON(http.StatusOK, note)
+	}
+}
+
+func CreateNote() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var note models.Note
+
+		if err := c.BindJSON(&note); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if note.Visibility == "" {
+			note.Visibility = models.NOTE_VISIBILITY_ALL
+		}
+
+		if validationErr := validate.Struct(note); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		if !canSeeNote(c.GetString("role"), note.Visibility) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "you cannot write notes with this visibility"})
+			return
+		}
+
+		if !checkNoteTarget(ctx, c, *note.Target_type, *note.Target_id) {
+			return
+		}
+
+		note.Author_id = c.GetString("uid")
+		note.Author_name = strings.TrimSpace(c.GetString("first_name") + " " + c.GetString("last_name"))
+		note.Created_at = time.Now()
+		note.Updated_at = time.Now()
+		note.ID = primitive.NewObjectID()
+		note.Note_id = note.ID.Hex()
+
+		if _, err := noteCollection.InsertOne(ctx, note); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Note was not created"})
+			return
+		}
+
+		publishNoteEvent(ctx, tasks.KITCHEN_NOTE_CREATED, note)
+
+		c.JSON(http.StatusOK, note)
+	}
+}
+
+// UpdateNote changes the text, title or visibility of a note. Only its author
+// and managers may change a note, the target stays the same.
+func UpdateNote() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var request struct {
+			Text       *string `json:"text" validate:"omitempty,min=1,max=1000"`
+			Title      *string `json:"title" validate:"omitempty,max=100"`
+			Visibility *string `json:"visibility" validate:"omitempty,eq=ALL|eq=KITCHEN|eq=FRONT_OF_HOUSE"`
+		}
+
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if validationErr := validate.Struct(request); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		note, ok := findVisibleNote(ctx, c)
+		if !ok {
+			return
+		}
+
+		if !canChangeNote(c, note) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "only the author or a manager can change this note"})
+			return
+		}
+
+		previous := note
+		update := bson.M{"updated_at": time.Now()}
+		if request.Text != nil {
+			update["text"] = *request.Text
+			note.Text = *request.Text
+		}
+		if request.Title != nil {
+			update["title"] = *request.Title
+			note.Title = *request.Title
+		}
+		if request.Visibility != nil {
+			if !canSeeNote(c.GetString("role"), *request.Visibility) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "you cannot write notes with this visibility"})
+				return
+			}
+			update["visibility"] = *request.Visibility
+			note.Visibility = *request.Visibility
+		}
+		note.Updated_at = update["updated_at"].(time.Time)
+
+		if _, err := noteCollection.UpdateOne(ctx, bson.M{"note_id": note.Note_id}, bson.M{"$set": update}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Note update failed"})
+			return
+		}
+
+		// A note that stops being visible to the kitchen is gone as far as
+		// the kitchen displays are concerned.
+		if canSeeNote(models.ROLE_KITCHEN, note.Visibility) {
+			publishNoteEvent(ctx, tasks.KITCHEN_NOTE_UPDATED, note)
+		} else if canSeeNote(models.ROLE_KITCHEN, previous.Visibility) {
+			publishNoteEvent(ctx, tasks.KITCHEN_NOTE_DELETED, previous)
+		}
+
+		c.JSON(http.StatusOK, note)
+	}
+}
+
+func DeleteNote() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		note, ok := findVisibleNote(ctx, c)
+		if !ok {
+			return
+		}
+
+		if !canChangeNote(c, note) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "only the author or a manager can delete this note"})
+			return
+		}
+
+		if _, err := noteCollection.DeleteOne(ctx, bson.M{"note_id": note.Note_id}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Note was not deleted"})
+			return
+		}
+
+		publishNoteEvent(ctx, tasks.KITCHEN_NOTE_DELETED, note)
+
+		c.JSON(http.StatusOK, gin.H{"message": "Note deleted successfully"})
+	}
+}
+
+// noteVisibilities returns the visibilities of the notes a role may see.
+func noteVisibilities(role string) []string {
+	switch role {
+	case models.ROLE_KITCHEN:
+		return []string{models.NOTE_VISIBILITY_ALL, models.NOTE_VISIBILITY_KITCHEN}
+	case models.ROLE_WAITER, models.ROLE_CASHIER:
+		return []string{models.NOTE_VISIBILITY_ALL, models.NOTE_VISIBILITY_FRONT_OF_HOUSE}
+	}
+	return []string{models.NOTE_VISIBILITY_ALL, models.NOTE_VISIBILITY_KITCHEN, models.NOTE_VISIBILITY_FRONT_OF_HOUSE}
+}
+
+func canSeeNote(role string, visibility string) bool {
+	for _, allowed := range noteVisibilities(role) {
+		if visibility == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+func canChangeNote(c *gin.Context, note models.Note) bool {
+	role := c.GetString("role")
+	return note.Author_id == c.GetString("uid") || role == models.ROLE_ADMIN || role == models.ROLE_MANAGER
+}
+
+// findVisibleNote loads the note of the request. Notes the caller may not see
+// are reported as not found.
+func findVisibleNote(ctx context.Context, c *gin.Context) (models.Note, bool) {
+	var note models.Note
+	err := noteCollection.FindOne(ctx, bson.M{"note_id": c.Param("note_id")}).Decode(&note)
+	if err != nil && err != mongo.ErrNoDocuments {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while fetching the note"})
+		return note, false
+	}
+	if err == mongo.ErrNoDocuments || !canSeeNote(c.GetString("role"), note.Visibility) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Note not found"})
+		return note, false
+	}
+	return note, true
+}
+
+// checkNoteTarget makes sure the order, order item or table a note is
+// attached to exists, answering the request itself when it does not.
+func checkNoteTarget(ctx context.Context, c *gin.Context, targetType string, targetId string) bool {
+	var collection *mongo.Collection
+	var filter bson.M
+
+	switch targetType {
+	case models.NOTE_TARGET_ORDER:
+		collection, filter = orderCollection, bson.M{"order_id": targetId}
+	case models.NOTE_TARGET_ORDER_ITEM:
+		collection, filter = orderItemCollection, bson.M{"order_item_id": targetId}
+	case models.NOTE_TARGET_TABLE:
+		collection, filter = tableCollection, bson.M{"table_id": targetId}
+	default:
+		return true
+	}
+
+	count, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while finding the note target"})
+		return false
+	}
+	if count == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": strings.ToLower(strings.ReplaceAll(targetType, "_", " ")) + " not found"})
+		return false
+	}
+	return true
+}
+
+// publishNoteEvent tells the kitchen displays about notes on orders and order
+// items they may see. Notes on order items go to the station of the item.
+func publishNoteEvent(ctx context.Context, eventType string, note models.Note) {
+	if !canSeeNote(models.ROLE_KITCHEN, note.Visibility) {
+		return
+	}
+
+	event := tasks.KitchenEvent{
+		Type:       eventType,
+		Visibility: note.Visibility,
+		Note:       note,
+	}
+
+	switch *note.Target_type {
+	case models.NOTE_TARGET_ORDER:
+		event.Order_id = *note.Target_id
+	case models.NOTE_TARGET_ORDER_ITEM:
+		var orderItem models.OrderItem
+		if err := orderItemCollection.FindOne(ctx, bson.M{"order_item_id": *note.Target_id}).Decode(&orderItem); err != nil {
+			return
+		}
+		event.Order_id = orderItem.Order_id
+		event.Station = kitchenStationOf(orderItem)
+	default:
+		return
+	}
+
+	publishKitchenEvent(event)
+}
+
+// notesByTarget loads the notes with the given visibilities of some targets
+// of one type, keyed by target id.
+func notesByTarget(ctx context.Context, targetType string, targetIds []string, visibilities []string) (map[string][]models.Note, error) {
+	notes := map[string][]models.Note{}
+	if len(targetIds) == 0 {
+		return notes, nil
+	}
+
+	cursor, err := noteCollection.Find(ctx, bson.M{
+		"target_type": targetType,
+		"target_id":   bson.M{"$in": targetIds},
+		"visibility":  bson.M{"$in": visibilities},
+	}, options.Find().SetSort(bson.M{"created_at": 1}))
+	if err != nil {
+		return nil, err
+	}
+
+	var found []models.Note
+	if err = cursor.All(ctx, &found); err != nil {
+		return nil, err
+	}
+	for _, note := range found {
+		notes[*note.Target_id] = append(notes[*note.Target_id], note)
+	}
+	return notes, nil
+}
+
+// noteLookup is an aggregation stage that puts the notes with the given
+// visibilities of the target whose id is in field into as.
+func noteLookup(targetType string, field string, visibilities []string, as string) bson.D {
+	return bson.D{{Key: "$lookup", Value: bson.D{
+		{Key: "from", Value: "note"},
+		{Key: "let", Value: bson.D{{Key: "target_id", Value: field}}},
+		{Key: "pipeline", Value: bson.A{
+			bson.D{{Key: "$match", Value: bson.D{
+				{Key: "target_type", Value: targetType},
+				{Key: "visibility", Value: bson.D{{Key: "$in", Value: visibilities}}},
+				{Key: "$expr", Value: bson.D{{Key: "$eq", Value: bson.A{"$target_id", "$$target_id"}}}},
+			}}},
+			bson.D{{Key: "$sort", Value: bson.D{{Key: "created_at", Value: 1}}}},
+		}},
+		{Key: "as", Value: as},
+	}}}
+}
@@ -0,0 +1,259 @@
+package controller
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	helper "golang-restaurant-management/helpers"
+	"golang-restaurant-management/models"
+	"golang-restaurant-management/tasks"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/oauth2"
+)
+
+// OIDCLogin starts a login at the identity provider using the authorization
+// code flow with PKCE. It redirects the browser, or with ?format=json returns
+// the URL for clients that redirect themselves.
+func OIDCLogin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		config, _, err := helper.OIDCConfig(ctx)
+		if !isOIDCAvailable(c, err) {
+			return
+		}
+
+		state, err := randomURLToken()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start the login"})
+			return
+		}
+		nonce, err := randomURLToken()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start the login"})
+			return
+		}
+		verifier := oauth2.GenerateVerifier()
+
+		err = tasks.StoreOIDCState(state, tasks.OIDCState{Nonce: nonce, Code_verifier: verifier})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start the login"})
+			return
+		}
+
+		authorizationURL := config.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+
+		if c.Query("format") == "json" {
+			c.JSON(http.StatusOK, gin.H{"authorization_url": authorizationURL})
+			return
+		}
+		c.Redirect(http.StatusFound, authorizationURL)
+	}
+}
+
+// OIDCCallback finishes the login the identity provider redirected back from.
+// The IdP user is linked to our user by subject, or by email on the first
+// login, and provisioned with OIDC_DEFAULT_ROLE if there is none yet. Linking
+// and provisioning by email need the IdP to have verified the address. The
+// response is the same as for Login, users with 2FA still get a challenge.
+func OIDCCallback() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		config, verifier, err := helper.OIDCConfig(ctx)
+		if !isOIDCAvailable(c, err) {
+			return
+		}
+
+		if idpErr := c.Query("error"); idpErr != "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "login at the identity provider failed: " + idpErr, "description": c.Query("error_description")})
+			return
+		}
+
+		stateData, err := tasks.TakeOIDCState(c.Query("state"))
+		if err != nil {
+			if err == tasks.ErrOIDCStateNotFound {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "the login has expired, please start again"})
+			} else {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve the login state"})
+			}
+			return
+		}
+
+		token, err := config.Exchange(ctx, c.Query("code"), oauth2.VerifierOption(stateData.Code_verifier))
+		if err != nil {
+			log.Printf("Error exchanging OIDC code: %v", err)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "the identity provider did not accept the login"})
+			return
+		}
+
+		rawIDToken, ok := token.Extra("id_token").(string)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "the identity provider returned no ID token"})
+			return
+		}
+
+		idToken, err := verifier.Verify(ctx, rawIDToken)
+		if err != nil {
+			log.Printf("Error verifying OIDC ID token: %v", err)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "the ID token is invalid"})
+			return
+		}
+
+		var claims helper.OIDCClaims
+		if err := idToken.Claims(&claims); err != nil || claims.Nonce != stateData.Nonce {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "the ID token is invalid"})
+			return
+		}
+
+		if claims.Email == "" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "the identity provider did not share an email address"})
+			return
+		}
+		foundUser, err := findOrProvisionOIDCUser(ctx, idToken.Issuer, claims)
+		if err == errOIDCEmailNotVerified {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			log.Printf("Error provisioning OIDC user: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign in with the identity provider"})
+			return
+		}
+
+		if foundUser.Is_deactivated {
+			c.JSON(http.StatusForbidden, gin.H{"error": "this account has been deactivated"})
+			return
+		}
+
+		respondWithSecondFactorOrSession(ctx, c, foundUser)
+	}
+}
+
+var errOIDCEmailNotVerified = errors.New("the identity provider has not verified your email address")
+
+func findOrProvisionOIDCUser(ctx context.Context, issuer string, claims helper.OIDCClaims) (models.User, error) {
+	var foundUser models.User
+
+	err := userCollection.FindOne(ctx, bson.M{"oidc_issuer": issuer, "oidc_subject": claims.Subject}).Decode(&foundUser)
+	if err != mongo.ErrNoDocuments {
+		return foundUser, err
+	}
+
+	// Anyone can put any address into an IdP account, only a verified one
+	// may claim an existing user or the address for a new one.
+	if claims.Email_verified == nil || !*claims.Email_verified {
+		return foundUser, errOIDCEmailNotVerified
+	}
+
+	// First login with the IdP, link the user with the same email. The IdP
+	// vouches for the address, so the user counts as verified.
+	emailPattern := primitive.Regex{Pattern: "^" + regexp.QuoteMeta(claims.Email) + "$", Options: "i"}
+	err = userCollection.FindOne(ctx, bson.M{"email": emailPattern, "erased_at": nil}).Decode(&foundUser)
+	if err == nil {
+		_, err = userCollection.UpdateOne(
+			ctx,
+			bson.M{"user_id": foundUser.User_id},
+			bson.M{"$set": bson.M{"oidc_issuer": issuer, "oidc_subject": claims.Subject, "is_verified": true, "updated_at": time.Now()}},
+		)
+		foundUser.Is_Verified = true
+		return foundUser, err
+	}
+	if err != mongo.ErrNoDocuments {
+		return foundUser, err
+	}
+
+	firstName, lastName := oidcUserNames(claims)
+	role := oidcDefaultRole()
+	// Users of the IdP have no local password and cannot log in with one
+	password := ""
+
+	user := models.User{
+		First_name:   &firstName,
+		Last_name:    &lastName,
+		Email:        &claims.Email,
+		Phone:        &claims.Phone_number,
+		Password:     &password,
+		Role:         &role,
+		Is_Verified:  true,
+		Oidc_issuer:  &issuer,
+		Oidc_subject: &claims.Subject,
+	}
+	if claims.Picture != "" {
+		user.Avatar = &claims.Picture
+	}
+	user.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	user.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	user.ID = primitive.NewObjectID()
+	user.User_id = user.ID.Hex()
+
+	_, err = userCollection.InsertOne(ctx, user)
+	return user, err
+}
+
+// oidcUserNames picks first and last name from the claims, falling back to
+// the full name and then the email.
+func oidcUserNames(claims helper.OIDCClaims) (string, string) {
+	firstName, lastName := claims.Given_name, claims.Family_name
+
+	if firstName == "" && claims.Name != "" {
+		parts := strings.Fields(claims.Name)
+		firstName = parts[0]
+		if lastName == "" && len(parts) > 1 {
+			lastName = strings.Join(parts[1:], " ")
+		}
+	}
+	if firstName == "" {
+		firstName = strings.Split(claims.Email, "@")[0]
+	}
+	if lastName == "" {
+		lastName = "-"
+	}
+	return firstName, lastName
+}
+
+// oidcDefaultRole is the role of users provisioned on their first IdP login.
+// It can never be ADMIN, admins are promoted by hand.
+func oidcDefaultRole() string {
+	switch role := os.Getenv("OIDC_DEFAULT_ROLE"); role {
+	case models.ROLE_MANAGER, models.ROLE_WAITER, models.ROLE_KITCHEN, models.ROLE_CASHIER:
+		return role
+	default:
+		return models.ROLE_WAITER
+	}
+}
+
+func isOIDCAvailable(c *gin.Context, err error) bool {
+	if err == helper.ErrOIDCNotConfigured {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return false
+	}
+	if err != nil {
+		log.Printf("Error discovering the OIDC provider: %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "the identity provider is not reachable"})
+		return false
+	}
+	return true
+}
+
+func randomURLToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -2,11 +2,14 @@ package controller
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"golang-restaurant-management/database"
 	"golang-restaurant-management/models"
+	"golang-restaurant-management/tasks"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -16,30 +19,33 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
-var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
-
 var orderCollection *mongo.Collection = database.OpenCollection(database.Client, "order")
 
 func GetOrders() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		typeFilter, ok := orderTypeFilter(c)
+		if !ok {
+			return
+		}
+		filter := bson.M{}
+		if typeFilter != nil {
+			filter = typeFilter
+		}
+
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 
-		result, err := orderCollection.Find(context.TODO(), bson.M{})
+		result, err := orderCollection.Find(context.TODO(), filter)
 		defer cancel()
 
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing order items"})
 		}
 
-		var allOrders []bson.M
+		allOrders := []bson.M{}
 		if err = result.All(ctx, &allOrders); err != nil {
 			log.Fatal(err)
 		}
-		if allOrders == nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "No orders found"})
-		} else {
-			c.JSON(http.StatusOK, allOrders)
-		}
+		c.JSON(http.StatusOK, allOrders)
 	}
 }
 
@@ -122,23 +128,16 @@ func CreateOrder() gin.HandlerFunc {
 			return
 		}
 
-		if order.Table_id != nil {
-			var table models.Table
-			err := tableCollection.FindOne(ctx, bson.M{"table_id": *order.Table_id}).Decode(&table)
-			if err != nil {
-				if err == mongo.ErrNoDocuments {
-					c.JSON(http.StatusNotFound, gin.H{"error": "Table not found"})
-				} else {
-					c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while finding table"})
-				}
-				return
-			}
+		if !checkOrderType(ctx, c, &order) {
+			return
 		}
 
 		order.Created_at = time.Now()
 		order.Updated_at = time.Now()
+		order.Created_by = c.GetString("uid")
 		order.ID = primitive.NewObjectID()
 		order.Order_id = order.ID.Hex()
+		placeOrder(&order)
 
 		result, insertErr := orderCollection.InsertOne(ctx, order)
 		if insertErr != nil {
@@ -159,9 +158,16 @@ func UpdateOrder() gin.HandlerFunc {
 		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 
+		// The driver and the fee of a delivery can be changed on their own,
+		// without sending all of its details again.
 		var updateData struct {
-			Order_Date time.Time `json:"order_date"`
-			Table_id   string    `json:"table_id"`
+			Order_Date   time.Time        `json:"order_date"`
+			Table_id     string           `json:"table_id"`
+			Takeaway     *models.Takeaway `json:"takeaway"`
+			Delivery     *models.Delivery `json:"delivery"`
+			Driver_name  *string          `json:"driver_name"`
+			Driver_phone *string          `json:"driver_phone"`
+			Delivery_fee *float64         `json:"delivery_fee" validate:"omitempty,min=0"`
 		}
 
 		orderId := c.Param("order_id")
@@ -171,25 +177,75 @@ func UpdateOrder() gin.HandlerFunc {
 			return
 		}
 
-		var updateObj primitive.D
-
-		if !updateData.Order_Date.IsZero() {
-			updateObj = append(updateObj, bson.E{"order_date", updateData.Order_Date})
+		if validationErr := validate.Struct(updateData); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
 		}
 
 		if updateData.Table_id != "" {
-			// Optionally, you can check if the table exists
-			var table models.Table
-			err := tableCollection.FindOne(ctx, bson.M{"table_id": updateData.Table_id}).Decode(&table)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "orders move to another table through POST /orders/:order_id/transfer"})
+			return
+		}
+
+		deliveryFields := updateData.Driver_name != nil || updateData.Driver_phone != nil || updateData.Delivery_fee != nil
+		if updateData.Delivery != nil && deliveryFields {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "send either the whole delivery or the fields to change"})
+			return
+		}
+
+		var updateObj primitive.D
+
+		// The takeaway or delivery details only fit orders of the matching
+		// type.
+		if updateData.Takeaway != nil || updateData.Delivery != nil || deliveryFields {
+			var order models.Order
+			err := orderCollection.FindOne(ctx, bson.M{"order_id": orderId}).Decode(&order)
 			if err != nil {
 				if err == mongo.ErrNoDocuments {
-					c.JSON(http.StatusNotFound, gin.H{"error": "Table not found"})
+					c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
 				} else {
-					c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while finding table"})
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while finding order"})
 				}
 				return
 			}
-			updateObj = append(updateObj, bson.E{"table_id", updateData.Table_id})
+
+			orderType := orderTypeOf(order)
+			if (updateData.Takeaway != nil && orderType != models.ORDER_TYPE_TAKEAWAY) ||
+				((updateData.Delivery != nil || deliveryFields) && orderType != models.ORDER_TYPE_DELIVERY) {
+				c.JSON(http.StatusConflict, gin.H{"error": "the update does not fit a " + orderType + " order"})
+				return
+			}
+
+			// The fee is billed, an invoice would no longer match it.
+			var currentFee *float64
+			if order.Delivery != nil {
+				currentFee = order.Delivery.Delivery_fee
+			}
+			feeChanged := updateData.Delivery_fee != nil ||
+				(updateData.Delivery != nil && !sameDeliveryFee(updateData.Delivery.Delivery_fee, currentFee))
+			if feeChanged && !checkOrderNotInvoiced(ctx, c, order.Order_id) {
+				return
+			}
+
+			if updateData.Takeaway != nil {
+				updateObj = append(updateObj, bson.E{"takeaway", updateData.Takeaway})
+			}
+			if updateData.Delivery != nil {
+				updateObj = append(updateObj, bson.E{"delivery", updateData.Delivery})
+			}
+			if updateData.Driver_name != nil {
+				updateObj = append(updateObj, bson.E{"delivery.driver_name", updateData.Driver_name})
+			}
+			if updateData.Driver_phone != nil {
+				updateObj = append(updateObj, bson.E{"delivery.driver_phone", updateData.Driver_phone})
+			}
+			if updateData.Delivery_fee != nil {
+				updateObj = append(updateObj, bson.E{"delivery.delivery_fee", updateData.Delivery_fee})
+			}
+		}
+
+		if !updateData.Order_Date.IsZero() {
+			updateObj = append(updateObj, bson.E{"order_date", updateData.Order_Date})
 		}
 
 		updateObj = append(updateObj, bson.E{"updated_at", time.Now()})
@@ -273,40 +329,300 @@ func UpdateOrder() gin.HandlerFunc {
 // 	}
 // }
 
-func OrderItemOrderCreator(order models.Order) string {
+// placeOrder starts a new order in the PLACED status, crediting whoever
+// created it.
+func placeOrder(order *models.Order) {
+	order.Status = models.ORDER_PLACED
+	order.Status_history = []models.OrderStatusChange{{
+		To:         models.ORDER_PLACED,
+		Actor_id:   order.Created_by,
+		Changed_at: order.Created_at,
+	}}
+}
+
+// orderStatus returns the status of an order, orders created before statuses
+// existed count as PLACED.
+func orderStatus(order models.Order) string {
+	if order.Status == "" {
+		return models.ORDER_PLACED
+	}
+	return order.Status
+}
+
+// orderTypeOf returns the type of an order, orders created before types
+// existed were all dine-in.
+func orderTypeOf(order models.Order) string {
+	if order.Type == "" {
+		return models.ORDER_TYPE_DINE_IN
+	}
+	return order.Type
+}
+
+// checkOrderType makes sure a new order carries what its type needs: a table
+// that exists for dine-in, the customer and pickup or delivery details
+// otherwise. It answers the request itself when the order does not fit.
+func checkOrderType(ctx context.Context, c *gin.Context, order *models.Order) bool {
+	order.Type = orderTypeOf(*order)
+	hasTable := order.Table_id != nil && *order.Table_id != ""
+
+	switch order.Type {
+	case models.ORDER_TYPE_DINE_IN:
+		if order.Takeaway != nil || order.Delivery != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "dine-in orders have no takeaway or delivery details"})
+			return false
+		}
+		if !hasTable {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Table ID is required for dine-in orders"})
+			return false
+		}
+		return checkTable(ctx, c, *order.Table_id)
+
+	case models.ORDER_TYPE_TAKEAWAY:
+		if order.Takeaway == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "takeaway orders need the customer and pickup time"})
+			return false
+		}
+		if order.Delivery != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "takeaway orders have no delivery details"})
+			return false
+		}
+
+	case models.ORDER_TYPE_DELIVERY:
+		if order.Delivery == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "delivery orders need the customer and address"})
+			return false
+		}
+		if order.Takeaway != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "delivery orders have no takeaway details"})
+			return false
+		}
+	}
+
+	if hasTable {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "only dine-in orders have a table"})
+		return false
+	}
+	order.Table_id = nil
+	return true
+}
+
+func sameDeliveryFee(a *float64, b *float64) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// orderTypeFilter reads the type query, a comma separated list of order
+// types, into a filter on orders. It is nil without the query and answers
+// the request itself when a type is unknown.
+func orderTypeFilter(c *gin.Context) (bson.M, bool) {
+	query := c.Query("type")
+	if query == "" {
+		return nil, true
+	}
+
+	types := []interface{}{}
+	for _, orderType := range strings.Split(query, ",") {
+		orderType = strings.ToUpper(strings.TrimSpace(orderType))
+		if orderType != models.ORDER_TYPE_DINE_IN && orderType != models.ORDER_TYPE_TAKEAWAY && orderType != models.ORDER_TYPE_DELIVERY {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "type must be DINE_IN, TAKEAWAY or DELIVERY"})
+			return nil, false
+		}
+		types = append(types, orderType)
+		if orderType == models.ORDER_TYPE_DINE_IN {
+			types = append(types, "", nil)
+		}
+	}
+	return bson.M{"type": bson.M{"$in": types}}, true
+}
+
+// filterByOrders narrows filter, on a collection with an order_id, to the
+// orders that match orderFilter. A nil orderFilter leaves it alone.
+func filterByOrders(ctx context.Context, filter bson.M, orderFilter bson.M) error {
+	if orderFilter == nil {
+		return nil
+	}
+	orderIds, err := orderCollection.Distinct(ctx, "order_id", orderFilter)
+	if err != nil {
+		return err
+	}
+	filter["order_id"] = bson.M{"$in": orderIds}
+	return nil
+}
+
+func checkTable(ctx context.Context, c *gin.Context, tableId string) bool {
+	count, err := tableCollection.CountDocuments(ctx, bson.M{"table_id": tableId})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while finding table"})
+		return false
+	}
+	if count == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Table not found"})
+		return false
+	}
+	return true
+}
+
+var errOrderChanged = errors.New("the order was changed by someone else, please try again")
+
+// orderStatusFilter matches the order only while it still has the status it
+// was read with. Filtering on it makes concurrent changes of the same order
+// fail instead of both being applied.
+func orderStatusFilter(order models.Order) bson.M {
+	current := orderStatus(order)
+	filter := bson.M{"order_id": order.Order_id, "status": current}
+	if current == models.ORDER_PLACED {
+		filter["status"] = bson.M{"$in": []interface{}{models.ORDER_PLACED, "", nil}}
+	}
+	return filter
+}
+
+// transitionOrder moves order to status. Callers check that the move is
+// allowed and tell the kitchen with publishOrderStatus once it is committed,
+// errOrderChanged means the order left the status it had when it was read.
+func transitionOrder(ctx context.Context, order models.Order, status string, actorId string, reason *string) (models.Order, error) {
+	current := orderStatus(order)
+	now := time.Now()
+	change := models.OrderStatusChange{
+		From:       current,
+		To:         status,
+		Actor_id:   actorId,
+		Reason:     reason,
+		Changed_at: now,
+	}
 
-	order.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-	order.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-	order.ID = primitive.NewObjectID()
-	order.Order_id = order.ID.Hex()
+	result, err := orderCollection.UpdateOne(ctx, orderStatusFilter(order), bson.M{
+		"$set": bson.M{
+			"status":     status,
+			"updated_at": now,
+		},
+		"$push": bson.M{"status_history": change},
+	})
+	if err != nil {
+		return order, err
+	}
+	if result.MatchedCount == 0 {
+		return order, errOrderChanged
+	}
 
-	orderCollection.InsertOne(ctx, order)
-	defer cancel()
+	order.Status = status
+	order.Updated_at = now
+	order.Status_history = append(order.Status_history, change)
 
-	return order.Order_id
+	return order, nil
 }
 
-func DeleteOrder() gin.HandlerFunc {
+// publishOrderStatus tells the kitchen about the latest status change of
+// order.
+func publishOrderStatus(order models.Order) {
+	change := order.Status_history[len(order.Status_history)-1]
+
+	kitchenEvent := tasks.KitchenEvent{
+		Type:       tasks.KITCHEN_ORDER_STATUS_CHANGED,
+		Order_id:   order.Order_id,
+		Order_type: orderTypeOf(order),
+		Status:     order.Status,
+		Reason:     change.Reason,
+	}
+	if order.Status == models.ORDER_CANCELLED {
+		kitchenEvent.Type = tasks.KITCHEN_ORDER_CANCELLED
+	}
+	if order.Table_id != nil {
+		kitchenEvent.Table_id = *order.Table_id
+	}
+	publishKitchenEvent(kitchenEvent)
+}
+
+func TransitionOrder() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var request struct {
+			Status string  `json:"status" validate:"required"`
+			Reason *string `json:"reason" validate:"omitempty,max=500"`
+		}
+
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if validationErr := validate.Struct(request); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		if _, ok := models.ORDER_TRANSITIONS[request.Status]; !ok || request.Status == models.ORDER_PLACED {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown order status"})
+			return
+		}
+
+		if request.Status == models.ORDER_CANCELLED {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "orders are cancelled with a reason code through POST /orders/:order_id/cancel"})
+			return
+		}
+
+		if request.Status == models.ORDER_MERGED {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "orders are merged through POST /orders/:order_id/merge"})
+			return
+		}
+
+		if !models.CanMoveOrderTo(c.GetString("role"), request.Status) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "you are not allowed to move an order to " + request.Status})
+			return
+		}
 
 		orderId := c.Param("order_id")
 
-		result, err := orderCollection.DeleteOne(ctx, bson.M{"order_id": orderId})
-		defer cancel()
+		var order models.Order
+		err := orderCollection.FindOne(ctx, bson.M{"order_id": orderId}).Decode(&order)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+			} else {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while finding order"})
+			}
+			return
+		}
 
-		if result.DeletedCount == 0 {
-			msg := fmt.Sprintf("order item not found")
-			c.JSON(http.StatusNotFound, gin.H{"error": msg})
+		current := orderStatus(order)
+		if !models.CanTransition(current, request.Status) {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("an order cannot move from %s to %s", current, request.Status)})
 			return
 		}
 
+		order, err = transitionOrder(ctx, order, request.Status, c.GetString("uid"), request.Reason)
+		if err == errOrderChanged {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
 		if err != nil {
-			msg := fmt.Sprintf("order item was not deleted")
-			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Order update failed"})
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"message": "order item deleted", "DeletedCount": result.DeletedCount})
+		publishOrderStatus(order)
+
+		// The stations may have finished every item before the order was
+		// accepted, then no item update is left to move it on to READY. Orders
+		// without kitchen items yet stay where they are.
+		if request.Status == models.ORDER_ACCEPTED || request.Status == models.ORDER_IN_PREPARATION {
+			kitchenItems, err := orderItemCollection.CountDocuments(ctx, bson.M{
+				"order_id":   order.Order_id,
+				"station_id": bson.M{"$nin": []interface{}{nil, ""}},
+				"void.type":  bson.M{"$ne": models.VOID_TYPE_VOID},
+			})
+			if err == nil && kitchenItems > 0 {
+				advanceOrderIfPrepared(ctx, order.Order_id, c.GetString("uid"))
+			}
+			if err := orderCollection.FindOne(ctx, bson.M{"order_id": order.Order_id}).Decode(&order); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while finding order"})
+				return
+			}
+		}
+
+		c.JSON(http.StatusOK, order)
 	}
 }
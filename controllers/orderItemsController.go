@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"golang-restaurant-management/database"
 	"golang-restaurant-management/models"
+	"golang-restaurant-management/tasks"
 	"log"
 	"net/http"
 	"time"
@@ -16,8 +17,13 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// OrderItemPack is a new order with its items. Dine-in orders name their
+// table, takeaway and delivery orders bring their own details instead.
 type OrderItemPack struct {
+	Type        string
 	Table_id    *string
+	Takeaway    *models.Takeaway
+	Delivery    *models.Delivery
 	Order_items []models.OrderItem
 }
 
@@ -25,26 +31,32 @@ var orderItemCollection *mongo.Collection = database.OpenCollection(database.Cli
 
 func GetOrderItems() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		typeFilter, ok := orderTypeFilter(c)
+		if !ok {
+			return
+		}
 
-		result, err := orderItemCollection.Find(context.TODO(), bson.M{})
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 
+		filter := bson.M{}
+		if err := filterByOrders(ctx, filter, typeFilter); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing ordered items"})
+			return
+		}
+
+		result, err := orderItemCollection.Find(context.TODO(), filter)
+
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing ordered items"})
 			return
 		}
-		var allOrderItems []bson.M
+		allOrderItems := []bson.M{}
 		if err = result.All(ctx, &allOrderItems); err != nil {
 			log.Fatal(err)
 			return
 		}
-		if allOrderItems == nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "No order items found"})
-
-		} else {
-			c.JSON(http.StatusOK, allOrderItems)
-		}
+		c.JSON(http.StatusOK, allOrderItems)
 
 	}
 }
@@ -52,7 +64,7 @@ func GetOrderItemsByOrder() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		orderId := c.Param("order_id")
 
-		allOrderItems, err := ItemsByOrder(orderId)
+		allOrderItems, err := ItemsByOrder(orderId, noteVisibilities(c.GetString("role")))
 
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Error occurred while listing order items by order ID: %s", err.Error())})
@@ -62,7 +74,10 @@ func GetOrderItemsByOrder() gin.HandlerFunc {
 	}
 }
 
-func ItemsByOrder(id string) (OrderItems []primitive.M, err error) {
+// ItemsByOrder groups the items of an order with its table and totals, a
+// delivery fee included. The notes on the order, its table and its items are
+// included when noteVisibilities says which ones to show.
+func ItemsByOrder(id string, noteVisibilities []string) (OrderItems []primitive.M, err error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
 	defer cancel()
 
@@ -78,37 +93,54 @@ func ItemsByOrder(id string) (OrderItems []primitive.M, err error) {
 
 	projectStage := bson.D{
 		{"$project", bson.D{
-			{"amount", "$food.price"},
+			{"amount", bson.D{{"$ifNull", bson.A{"$total_price", "$food.price"}}}},
 			{"total_count", 1},
 			{"food_name", "$food.name"},
 			{"food_image", "$food.food_image"},
 			{"table_number", "$table.table_number"},
 			{"table_id", "$table.table_id"},
 			{"order_id", "$order.order_id"},
+			{"order_type", "$order.type"},
+			{"delivery_fee", "$order.delivery.delivery_fee"},
 			{"price", "$food.price"},
+			{"order_item_id", 1},
 			{"quantity", 1},
+			{"unit_price", 1},
+			{"total_price", 1},
+			{"modifiers", 1},
+			{"notes", 1},
+			{"void", 1},
 		}}}
 
+	// Voided and comped items are listed with their void but add nothing to
+	// the totals.
+	isVoided := bson.D{{"$gt", bson.A{"$void", nil}}}
+
 	groupStage := bson.D{{"$group", bson.D{
 		{"_id", bson.D{
 			{"order_id", "$order_id"},
 			{"table_id", "$table_id"},
 			{"table_number", "$table_number"},
 		}},
-		{"payment_due", bson.D{{"$sum", "$amount"}}},
-		{"total_count", bson.D{{"$sum", 1}}},
+		{"payment_due", bson.D{{"$sum", bson.D{{"$cond", bson.A{isVoided, 0, "$amount"}}}}}},
+		{"total_count", bson.D{{"$sum", bson.D{{"$cond", bson.A{isVoided, 0, 1}}}}}},
+		{"order_type", bson.D{{"$first", "$order_type"}}},
+		{"delivery_fee", bson.D{{"$first", "$delivery_fee"}}},
 		{"order_items", bson.D{{"$push", "$$ROOT"}}},
 	}}}
 
+	// The delivery fee of delivery orders is billed on top of the items.
 	projectStage2 := bson.D{
 		{"$project", bson.D{
-			{"payment_due", 1},
+			{"payment_due", bson.D{{"$add", bson.A{"$payment_due", bson.D{{"$ifNull", bson.A{"$delivery_fee", 0}}}}}}},
 			{"total_count", 1},
+			{"order_type", bson.D{{"$ifNull", bson.A{"$order_type", models.ORDER_TYPE_DINE_IN}}}},
+			{"delivery_fee", 1},
 			{"table_number", "$_id.table_number"},
 			{"order_items", 1},
 		}}}
 
-	result, err := orderItemCollection.Aggregate(ctx, mongo.Pipeline{
+	pipeline := mongo.Pipeline{
 		matchStage,
 		lookupStage,
 		unwindStage,
@@ -116,9 +148,18 @@ func ItemsByOrder(id string) (OrderItems []primitive.M, err error) {
 		unwindOrderStage,
 		lookupTableStage,
 		unwindTableStage,
-		projectStage,
-		groupStage,
-		projectStage2})
+	}
+	if noteVisibilities != nil {
+		pipeline = append(pipeline, noteLookup(models.NOTE_TARGET_ORDER_ITEM, "$order_item_id", noteVisibilities, "notes"))
+	}
+	pipeline = append(pipeline, projectStage, groupStage, projectStage2)
+	if noteVisibilities != nil {
+		pipeline = append(pipeline,
+			noteLookup(models.NOTE_TARGET_ORDER, "$_id.order_id", noteVisibilities, "order_notes"),
+			noteLookup(models.NOTE_TARGET_TABLE, "$_id.table_id", noteVisibilities, "table_notes"))
+	}
+
+	result, err := orderItemCollection.Aggregate(ctx, pipeline)
 
 	if err != nil {
 		return nil, fmt.Errorf("error in aggregation: %w", err)
@@ -213,6 +254,9 @@ func UpdateOrderItem() gin.HandlerFunc {
 	}
 }
 
+// CreateOrderItem places a new order together with its items.
+// Everything is checked before anything is written, and the order and its
+// items are inserted in one transaction so a failure leaves neither behind.
 func CreateOrderItem() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
@@ -226,86 +270,186 @@ func CreateOrderItem() gin.HandlerFunc {
 			return
 		}
 
-		if orderItemPack.Table_id == nil || len(*orderItemPack.Table_id) == 0 {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Table ID is required"})
-			return
-		}
-
 		if orderItemPack.Order_items == nil || len(orderItemPack.Order_items) == 0 {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Order items are required"})
 			return
 		}
 
-		order.Order_Date, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		now := time.Now()
+		order.Order_Date = now
+		order.Type = orderItemPack.Type
 		order.Table_id = orderItemPack.Table_id
-		order_id := OrderItemOrderCreator(order)
+		order.Takeaway = orderItemPack.Takeaway
+		order.Delivery = orderItemPack.Delivery
 
-		orderItemsToBeInserted := []interface{}{}
+		if validationErr := validate.Struct(order); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
 
-		for _, orderItem := range orderItemPack.Order_items {
-			orderItem.Order_id = order_id
+		if !checkOrderType(ctx, c, &order) {
+			return
+		}
+
+		tableId := ""
+		if order.Table_id != nil {
+			tableId = *order.Table_id
+		}
+
+		order.Created_at = now
+		order.Updated_at = now
+		order.Created_by = c.GetString("uid")
+		order.ID = primitive.NewObjectID()
+		order.Order_id = order.ID.Hex()
+		placeOrder(&order)
+
+		orderItems := []models.OrderItem{}
+		orderItemsToBeInserted := []interface{}{}
+		kitchenEvents := []tasks.KitchenEvent{}
 
+		for i, orderItem := range orderItemPack.Order_items {
 			if orderItem.Quantity == nil {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "Quantity is required"})
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Quantity is required for item %d", i+1)})
 				return
 			}
 
 			if orderItem.Food_id == nil {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "Food ID is required"})
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Food ID is required for item %d", i+1)})
 				return
 			}
 
-			// Fetch the food item from the database using Food_id
 			var food models.Food
 			err := foodCollection.FindOne(ctx, bson.M{"food_id": *orderItem.Food_id}).Decode(&food)
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Food item not found"})
+				if err == mongo.ErrNoDocuments {
+					c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Food item %s not found", *orderItem.Food_id)})
+				} else {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while finding food item"})
+				}
 				return
 			}
 
-			orderItem.Unit_price = food.Price
-			totalPrice := *orderItem.Unit_price * float64(*orderItem.Quantity)
+			modifiers, modifiersPrice, msg := selectModifiers(food, orderItem.Modifiers)
+			if msg != "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+				return
+			}
+			orderItem.Modifiers = modifiers
+
+			unitPrice := toFixed(*food.Price+modifiersPrice, 2)
+			orderItem.Unit_price = &unitPrice
+			totalPrice := toFixed(*orderItem.Unit_price*float64(*orderItem.Quantity), 2)
 			orderItem.Total_price = &totalPrice
 
 			orderItem.ID = primitive.NewObjectID()
-			orderItem.Created_at = time.Now()
-			orderItem.Updated_at = time.Now()
+			orderItem.Order_id = order.Order_id
+			orderItem.Created_at = now
+			orderItem.Updated_at = now
 			orderItem.Order_item_id = orderItem.ID.Hex()
+			orderItem.Prep_status = models.PREP_QUEUED
+			orderItem.Prep_started_at = nil
+			orderItem.Prep_done_at = nil
+			orderItem.Station_id = nil
+			if station := kitchenStation(ctx, food); station != "" {
+				orderItem.Station_id = &station
+			}
 
+			if validationErr := validate.Struct(orderItem); validationErr != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+				return
+			}
+
+			orderItems = append(orderItems, orderItem)
 			orderItemsToBeInserted = append(orderItemsToBeInserted, orderItem)
+			kitchenEvents = append(kitchenEvents, tasks.KitchenEvent{
+				Type:       tasks.KITCHEN_ORDER_ITEM_CREATED,
+				Order_id:   order.Order_id,
+				Order_type: order.Type,
+				Table_id:   tableId,
+				Station:    kitchenStationOf(orderItem),
+				Order_item: kitchenTicket(orderItem, food),
+			})
 		}
 
-		insertedOrderItems, err := orderItemCollection.InsertMany(ctx, orderItemsToBeInserted)
+		err := database.RunTransaction(ctx, func(sessionCtx mongo.SessionContext) error {
+			if _, err := orderCollection.InsertOne(sessionCtx, order); err != nil {
+				return err
+			}
+			_, err := orderItemCollection.InsertMany(sessionCtx, orderItemsToBeInserted)
+			return err
+		})
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Order was not created"})
 			return
 		}
 
-		c.JSON(http.StatusOK, insertedOrderItems)
+		for _, event := range kitchenEvents {
+			publishKitchenEvent(event)
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"order":       order,
+			"order_items": orderItems,
+		})
 	}
 }
 
-func DeleteOrderItem() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
-
-		orderItemId := c.Param("order_item_id")
+// selectModifiers checks the modifiers picked for a food against its groups
+// and returns them with their names and prices filled in, together with what
+// they add to the unit price.
+func selectModifiers(food models.Food, selected []models.SelectedModifier) ([]models.SelectedModifier, float64, string) {
+	type groupOption struct {
+		group  models.ModifierGroup
+		option models.ModifierOption
+	}
+	choices := map[string]groupOption{}
+	for _, group := range food.Modifier_groups {
+		for _, option := range group.Options {
+			choices[group.Group_id+"/"+option.Option_id] = groupOption{group: group, option: option}
+		}
+	}
 
-		result, err := orderItemCollection.DeleteOne(ctx, bson.M{"order_item_id": orderItemId})
+	modifiers := []models.SelectedModifier{}
+	counts := map[string]int{}
+	picked := map[string]bool{}
+	price := 0.0
 
-		if result.DeletedCount == 0 {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Not found order item"})
-			return
+	for _, modifier := range selected {
+		key := modifier.Group_id + "/" + modifier.Option_id
+		found, ok := choices[key]
+		if !ok {
+			return nil, 0, fmt.Sprintf("%s has no modifier option %s in group %s", *food.Name, modifier.Option_id, modifier.Group_id)
 		}
-
-		if err != nil {
-			msg := "Order item delete failed"
-			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
-			return
+		if picked[key] {
+			return nil, 0, fmt.Sprintf("modifier %s is selected twice", found.option.Name)
 		}
+		picked[key] = true
+		counts[found.group.Group_id]++
+		price += found.option.Price_delta
+
+		modifiers = append(modifiers, models.SelectedModifier{
+			Group_id:    found.group.Group_id,
+			Option_id:   found.option.Option_id,
+			Group_name:  found.group.Name,
+			Name:        found.option.Name,
+			Price_delta: found.option.Price_delta,
+		})
+	}
 
-		defer cancel()
+	for _, group := range food.Modifier_groups {
+		count := counts[group.Group_id]
 
-		c.JSON(http.StatusOK, result)
+		min := group.Min_selections
+		if group.Required && min < 1 {
+			min = 1
+		}
+		if count < min && (group.Required || count > 0) {
+			return nil, 0, fmt.Sprintf("%s needs at least %d selections for %s", *food.Name, min, group.Name)
+		}
+		if group.Max_selections > 0 && count > group.Max_selections {
+			return nil, 0, fmt.Sprintf("%s allows at most %d selections for %s", *food.Name, group.Max_selections, group.Name)
+		}
 	}
+
+	return modifiers, price, ""
 }
@@ -0,0 +1,552 @@
+package controller
+
+import (
+	"context"
+	"golang-restaurant-management/database"
+	"golang-restaurant-management/models"
+	"golang-restaurant-management/tasks"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// TransferOrder moves an open order to another table.
+func TransferOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var request struct {
+			Table_id string `json:"table_id" validate:"required"`
+		}
+
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if validationErr := validate.Struct(request); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		order, ok := findOpenOrder(ctx, c, c.Param("order_id"))
+		if !ok {
+			return
+		}
+
+		if orderTypeOf(order) != models.ORDER_TYPE_DINE_IN {
+			c.JSON(http.StatusConflict, gin.H{"error": "only dine-in orders have a table"})
+			return
+		}
+
+		if order.Table_id != nil && *order.Table_id == request.Table_id {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "the order is already at this table"})
+			return
+		}
+
+		if !checkTable(ctx, c, request.Table_id) {
+			return
+		}
+
+		move := models.OrderMove{
+			Type:          models.ORDER_MOVE_TRANSFER,
+			From_table_id: order.Table_id,
+			To_table_id:   &request.Table_id,
+			Order_id:      order.Order_id,
+			Actor_id:      c.GetString("uid"),
+			Moved_at:      time.Now(),
+		}
+
+		result, err := orderCollection.UpdateOne(
+			ctx,
+			orderStatusFilter(order),
+			bson.M{
+				"$set":  bson.M{"table_id": request.Table_id, "updated_at": move.Moved_at},
+				"$push": bson.M{"moves": move},
+			},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Order update failed"})
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": errOrderChanged.Error()})
+			return
+		}
+
+		order.Table_id = &request.Table_id
+		order.Updated_at = move.Moved_at
+		order.Moves = append(order.Moves, move)
+
+		publishKitchenEvent(tasks.KitchenEvent{
+			Type:       tasks.KITCHEN_ORDER_TRANSFERRED,
+			Order_id:   order.Order_id,
+			Order_type: models.ORDER_TYPE_DINE_IN,
+			Table_id:   request.Table_id,
+			Status:     orderStatus(order),
+		})
+
+		c.JSON(http.StatusOK, order)
+	}
+}
+
+// MergeOrders moves all items of another open order into this one, which
+// ends the other order as MERGED. A pending invoice of the other order moves
+// along unless this order already has one, then it is voided as superseded by
+// the invoice of this order.
+func MergeOrders() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var request struct {
+			Order_id string `json:"order_id" validate:"required"`
+		}
+
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if validationErr := validate.Struct(request); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		if request.Order_id == c.Param("order_id") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "an order cannot be merged into itself"})
+			return
+		}
+
+		order, ok := findOpenOrder(ctx, c, c.Param("order_id"))
+		if !ok {
+			return
+		}
+		source, ok := findOpenOrder(ctx, c, request.Order_id)
+		if !ok {
+			return
+		}
+		if orderTypeOf(order) != orderTypeOf(source) {
+			c.JSON(http.StatusConflict, gin.H{"error": "only orders of the same type can be merged"})
+			return
+		}
+		if !checkOrderUnpaid(ctx, c, order.Order_id) || !checkOrderUnpaid(ctx, c, source.Order_id) {
+			return
+		}
+
+		orderItemIds, err := orderItemIdsOf(ctx, source.Order_id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while finding order items"})
+			return
+		}
+
+		now := time.Now()
+		actorId := c.GetString("uid")
+		reason := "merged into " + order.Order_id
+
+		// The transaction may run the function more than once, so it must
+		// start from the loaded orders every time.
+		var merged models.Order
+		err = database.RunTransaction(ctx, func(sessionCtx mongo.SessionContext) error {
+			var err error
+			merged, err = transitionOrder(sessionCtx, source, models.ORDER_MERGED, actorId, &reason)
+			if err != nil {
+				return err
+			}
+
+			moved, err := orderItemCollection.UpdateMany(
+				sessionCtx,
+				bson.M{"order_id": source.Order_id},
+				bson.M{"$set": bson.M{"order_id": order.Order_id, "updated_at": now}},
+			)
+			if err != nil {
+				return err
+			}
+			if moved.MatchedCount != int64(len(orderItemIds)) {
+				return errOrderChanged
+			}
+
+			_, err = orderCollection.UpdateOne(sessionCtx, bson.M{"order_id": source.Order_id}, bson.M{
+				"$set": bson.M{"merged_into": order.Order_id},
+				"$push": bson.M{"moves": models.OrderMove{
+					Type:           models.ORDER_MOVE_MERGE,
+					From_table_id:  source.Table_id,
+					To_table_id:    order.Table_id,
+					Order_id:       order.Order_id,
+					Order_item_ids: orderItemIds,
+					Actor_id:       actorId,
+					Moved_at:       now,
+				}},
+			})
+			if err != nil {
+				return err
+			}
+
+			result, err := orderCollection.UpdateOne(sessionCtx, orderStatusFilter(order), bson.M{
+				"$set": bson.M{"updated_at": now},
+				"$push": bson.M{"moves": models.OrderMove{
+					Type:           models.ORDER_MOVE_MERGE,
+					From_table_id:  source.Table_id,
+					To_table_id:    order.Table_id,
+					Order_id:       source.Order_id,
+					Order_item_ids: orderItemIds,
+					Actor_id:       actorId,
+					Moved_at:       now,
+				}},
+			})
+			if err != nil {
+				return err
+			}
+			if result.MatchedCount == 0 {
+				return errOrderChanged
+			}
+
+			// The invoice of this order bills the merged items from now on,
+			// otherwise the invoice of the other order takes over.
+			sourceInvoices := bson.M{"order_id": source.Order_id, "payment_status": bson.M{"$ne": models.INVOICE_VOID}}
+			invoices, err := invoiceCollection.CountDocuments(sessionCtx, bson.M{"order_id": order.Order_id, "payment_status": bson.M{"$ne": models.INVOICE_VOID}})
+			if err != nil {
+				return err
+			}
+			if invoices > 0 {
+				_, err = invoiceCollection.UpdateMany(
+					sessionCtx,
+					sourceInvoices,
+					bson.M{"$set": bson.M{"payment_status": models.INVOICE_VOID, "superseded_by": order.Order_id, "updated_at": now}},
+				)
+			} else {
+				_, err = invoiceCollection.UpdateMany(
+					sessionCtx,
+					sourceInvoices,
+					bson.M{"$set": bson.M{"order_id": order.Order_id, "updated_at": now}},
+				)
+			}
+			return err
+		})
+		if err == errOrderChanged {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Orders were not merged"})
+			return
+		}
+
+		source = merged
+		publishOrderStatus(source)
+
+		c.JSON(http.StatusOK, gin.H{
+			"order_id":        order.Order_id,
+			"merged_order_id": source.Order_id,
+			"order_item_ids":  orderItemIds,
+		})
+	}
+}
+
+// SplitOrder moves selected items of an order into a new order, at the same
+// table unless another one is given. An item moves whole, or only some of its
+// quantity, or a share of its price when guests split a single item. Shares
+// are billing only, the kitchen keeps preparing the item they came from.
+func SplitOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var request struct {
+			Table_id *string `json:"table_id"`
+			Items    []struct {
+				Order_item_id string   `json:"order_item_id" validate:"required"`
+				Quantity      *int     `json:"quantity" validate:"omitempty,min=1"`
+				Share         *float64 `json:"share" validate:"omitempty,gt=0,lt=1"`
+			} `json:"items" validate:"required,min=1,dive"`
+		}
+
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if validationErr := validate.Struct(request); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		order, ok := findOpenOrder(ctx, c, c.Param("order_id"))
+		if !ok {
+			return
+		}
+		if !checkOrderUnpaid(ctx, c, order.Order_id) {
+			return
+		}
+
+		tableId := order.Table_id
+		if request.Table_id != nil {
+			if orderTypeOf(order) != models.ORDER_TYPE_DINE_IN {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "only dine-in orders have a table"})
+				return
+			}
+			if !checkTable(ctx, c, *request.Table_id) {
+				return
+			}
+			tableId = request.Table_id
+		}
+
+		cursor, err := orderItemCollection.Find(ctx, bson.M{"order_id": order.Order_id, "void": nil})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while finding order items"})
+			return
+		}
+		var orderItems []models.OrderItem
+		if err = cursor.All(ctx, &orderItems); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while finding order items"})
+			return
+		}
+		openItems := map[string]models.OrderItem{}
+		for _, orderItem := range orderItems {
+			openItems[orderItem.Order_item_id] = orderItem
+		}
+
+		now := time.Now()
+		actorId := c.GetString("uid")
+
+		newOrder := models.Order{
+			ID:         primitive.NewObjectID(),
+			Order_Date: now,
+			Created_at: now,
+			Updated_at: now,
+			Type:       order.Type,
+			Table_id:   tableId,
+			Takeaway:   order.Takeaway,
+			Created_by: actorId,
+			Status:     orderStatus(order),
+			Split_from: &order.Order_id,
+		}
+		newOrder.Order_id = newOrder.ID.Hex()
+		if order.Delivery != nil {
+			// Both parts go to the same address, the fee is only billed once.
+			delivery := *order.Delivery
+			delivery.Delivery_fee = nil
+			newOrder.Delivery = &delivery
+		}
+		reason := "split from " + order.Order_id
+		newOrder.Status_history = []models.OrderStatusChange{{
+			To:         newOrder.Status,
+			Actor_id:   actorId,
+			Reason:     &reason,
+			Changed_at: now,
+		}}
+
+		// Items that move whole only change their order, split items keep
+		// their remaining part and a copy takes the part that moves.
+		movedIds := []string{}
+		remainingItems := map[string]models.OrderItem{}
+		newItems := []models.OrderItem{}
+		wholeMoves := 0
+
+		for _, selected := range request.Items {
+			orderItem, ok := openItems[selected.Order_item_id]
+			if !ok {
+				c.JSON(http.StatusNotFound, gin.H{"error": "order item " + selected.Order_item_id + " is not an open item of this order"})
+				return
+			}
+			if _, seen := remainingItems[orderItem.Order_item_id]; seen {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "order item " + selected.Order_item_id + " is selected twice"})
+				return
+			}
+			if selected.Quantity != nil && selected.Share != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "split an item by quantity or by share, not both"})
+				return
+			}
+			if selected.Quantity != nil && *selected.Quantity > *orderItem.Quantity {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "cannot move more than the ordered quantity of " + selected.Order_item_id})
+				return
+			}
+
+			movedIds = append(movedIds, orderItem.Order_item_id)
+
+			if selected.Share == nil && (selected.Quantity == nil || *selected.Quantity == *orderItem.Quantity) {
+				remainingItems[orderItem.Order_item_id] = models.OrderItem{}
+				moved := orderItem
+				moved.Order_id = newOrder.Order_id
+				moved.Updated_at = now
+				newItems = append(newItems, moved)
+				wholeMoves++
+				continue
+			}
+
+			orderItem, part := models.SplitOrderItem(orderItem, selected.Quantity, selected.Share)
+			orderItem.Updated_at = now
+			part.ID = primitive.NewObjectID()
+			part.Order_item_id = part.ID.Hex()
+			part.Order_id = newOrder.Order_id
+			part.Split_from = &orderItem.Order_item_id
+			part.Created_at = now
+			part.Updated_at = now
+
+			remainingItems[orderItem.Order_item_id] = orderItem
+			newItems = append(newItems, part)
+		}
+
+		if wholeMoves == len(orderItems) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "nothing would be left on the order, transfer it instead"})
+			return
+		}
+
+		newOrder.Moves = []models.OrderMove{{
+			Type:           models.ORDER_MOVE_SPLIT,
+			From_table_id:  order.Table_id,
+			To_table_id:    tableId,
+			Order_id:       order.Order_id,
+			Order_item_ids: movedIds,
+			Actor_id:       actorId,
+			Moved_at:       now,
+		}}
+
+		err = database.RunTransaction(ctx, func(sessionCtx mongo.SessionContext) error {
+			result, err := orderCollection.UpdateOne(sessionCtx, orderStatusFilter(order), bson.M{
+				"$set": bson.M{"updated_at": now},
+				"$push": bson.M{"moves": models.OrderMove{
+					Type:           models.ORDER_MOVE_SPLIT,
+					From_table_id:  order.Table_id,
+					To_table_id:    tableId,
+					Order_id:       newOrder.Order_id,
+					Order_item_ids: movedIds,
+					Actor_id:       actorId,
+					Moved_at:       now,
+				}},
+			})
+			if err != nil {
+				return err
+			}
+			if result.MatchedCount == 0 {
+				return errOrderChanged
+			}
+
+			if _, err := orderCollection.InsertOne(sessionCtx, newOrder); err != nil {
+				return err
+			}
+
+			for _, orderItem := range newItems {
+				if orderItem.Split_from == nil {
+					_, err = orderItemCollection.UpdateOne(
+						sessionCtx,
+						bson.M{"order_item_id": orderItem.Order_item_id, "void": nil},
+						bson.M{"$set": bson.M{"order_id": newOrder.Order_id, "updated_at": now}},
+					)
+					if err != nil {
+						return err
+					}
+					continue
+				}
+
+				remaining := remainingItems[*orderItem.Split_from]
+				_, err = orderItemCollection.UpdateOne(
+					sessionCtx,
+					bson.M{"order_item_id": remaining.Order_item_id, "void": nil},
+					bson.M{"$set": bson.M{
+						"quantity":    remaining.Quantity,
+						"share":       remaining.Share,
+						"total_price": remaining.Total_price,
+						"updated_at":  now,
+					}},
+				)
+				if err != nil {
+					return err
+				}
+				if _, err = orderItemCollection.InsertOne(sessionCtx, orderItem); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err == errOrderChanged {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Order was not split"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"order_id":    order.Order_id,
+			"order":       newOrder,
+			"order_items": newItems,
+		})
+	}
+}
+
+// findOpenOrder loads an order that can still change, answering the request
+// itself when there is none.
+func findOpenOrder(ctx context.Context, c *gin.Context, orderId string) (models.Order, bool) {
+	var order models.Order
+	err := orderCollection.FindOne(ctx, bson.M{"order_id": orderId}).Decode(&order)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Order " + orderId + " not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while finding order"})
+		}
+		return order, false
+	}
+
+	if status := orderStatus(order); len(models.ORDER_TRANSITIONS[status]) == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "order " + orderId + " is " + status})
+		return order, false
+	}
+
+	return order, true
+}
+
+// checkOrderUnpaid refuses to change the items of orders whose invoice was
+// paid, the payment would no longer match the bill.
+func checkOrderUnpaid(ctx context.Context, c *gin.Context, orderId string) bool {
+	paid, err := invoiceCollection.CountDocuments(ctx, bson.M{"order_id": orderId, "payment_status": models.INVOICE_PAID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while finding invoices"})
+		return false
+	}
+	if paid > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "order " + orderId + " was already paid"})
+		return false
+	}
+	return true
+}
+
+// checkOrderNotInvoiced refuses changes to what an order bills once it was
+// invoiced, the invoice would no longer match.
+func checkOrderNotInvoiced(ctx context.Context, c *gin.Context, orderId string) bool {
+	invoices, err := invoiceCollection.CountDocuments(ctx, bson.M{"order_id": orderId, "payment_status": bson.M{"$ne": models.INVOICE_VOID}})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while finding invoices"})
+		return false
+	}
+	if invoices > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "order " + orderId + " was already invoiced"})
+		return false
+	}
+	return true
+}
+
+func orderItemIdsOf(ctx context.Context, orderId string) ([]string, error) {
+	cursor, err := orderItemCollection.Find(ctx, bson.M{"order_id": orderId})
+	if err != nil {
+		return nil, err
+	}
+
+	var orderItems []models.OrderItem
+	if err = cursor.All(ctx, &orderItems); err != nil {
+		return nil, err
+	}
+
+	orderItemIds := []string{}
+	for _, orderItem := range orderItems {
+		orderItemIds = append(orderItemIds, orderItem.Order_item_id)
+	}
+	return orderItemIds, nil
+}
@@ -0,0 +1,114 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// GetVoidReport is the shrinkage report: voided and comped items between
+// ?from and ?to (RFC 3339 or YYYY-MM-DD, the last 7 days by default), summed
+// per type and reason code, the items themselves and the cancelled orders.
+func GetVoidReport() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		to := time.Now()
+		from := to.AddDate(0, 0, -7)
+		var ok bool
+		if from, ok = reportTime(c, "from", from); !ok {
+			return
+		}
+		if to, ok = reportTime(c, "to", to); !ok {
+			return
+		}
+
+		period := bson.M{"$gte": from, "$lt": to}
+
+		summaryCursor, err := orderItemCollection.Aggregate(ctx, mongo.Pipeline{
+			{{Key: "$match", Value: bson.M{"void.voided_at": period}}},
+			{{Key: "$group", Value: bson.M{
+				"_id":   bson.M{"type": "$void.type", "reason_code": "$void.reason_code"},
+				"items": bson.M{"$sum": 1},
+				// Billing only shares were never made on their own.
+				"quantity": bson.M{"$sum": bson.M{"$cond": bson.A{"$billing_only", 0, "$quantity"}}},
+				"amount":   bson.M{"$sum": "$total_price"},
+				"fired":    bson.M{"$sum": bson.M{"$cond": bson.A{"$void.fired", 1, 0}}},
+			}}},
+			{{Key: "$project", Value: bson.M{
+				"_id":         0,
+				"type":        "$_id.type",
+				"reason_code": "$_id.reason_code",
+				"items":       1,
+				"quantity":    1,
+				"amount":      1,
+				"fired":       1,
+			}}},
+			{{Key: "$sort", Value: bson.D{{Key: "type", Value: 1}, {Key: "amount", Value: -1}}}},
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while building the void report"})
+			return
+		}
+		summary := []bson.M{}
+		if err = summaryCursor.All(ctx, &summary); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while building the void report"})
+			return
+		}
+
+		itemCursor, err := orderItemCollection.Find(ctx, bson.M{"void.voided_at": period}, options.Find().SetSort(bson.M{"void.voided_at": 1}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while building the void report"})
+			return
+		}
+		items := []bson.M{}
+		if err = itemCursor.All(ctx, &items); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while building the void report"})
+			return
+		}
+
+		orderCursor, err := orderCollection.Find(ctx, bson.M{"cancellation.voided_at": period}, options.Find().SetSort(bson.M{"cancellation.voided_at": 1}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while building the void report"})
+			return
+		}
+		orders := []bson.M{}
+		if err = orderCursor.All(ctx, &orders); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while building the void report"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"from":             from,
+			"to":               to,
+			"summary":          summary,
+			"items":            items,
+			"cancelled_orders": orders,
+		})
+	}
+}
+
+// reportTime reads a time query parameter, answering the request itself when
+// it is malformed.
+func reportTime(c *gin.Context, name string, fallback time.Time) (time.Time, bool) {
+	value := c.Query(name)
+	if value == "" {
+		return fallback, true
+	}
+
+	if parsed, err := time.Parse(time.RFC3339, value); err == nil {
+		return parsed, true
+	}
+	if parsed, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return parsed, true
+	}
+
+	c.JSON(http.StatusBadRequest, gin.H{"error": name + " must be an RFC 3339 time or a YYYY-MM-DD date"})
+	return fallback, false
+}
@@ -0,0 +1,179 @@
+package controller
+
+import (
+	"context"
+	"golang-restaurant-management/database"
+	"golang-restaurant-management/models"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var settingCollection *mongo.Collection = database.OpenCollection(database.Client, "setting")
+
+const twoFactorPolicyKey = "two_factor_policy"
+const voidPolicyKey = "void_policy"
+
+func getTwoFactorPolicy(ctx context.Context) (models.TwoFactorPolicy, error) {
+	var policy models.TwoFactorPolicy
+
+	err := settingCollection.FindOne(ctx, bson.M{"key": twoFactorPolicyKey}).Decode(&policy)
+	if err == mongo.ErrNoDocuments {
+		return models.TwoFactorPolicy{Key: twoFactorPolicyKey, Required_roles: []string{}}, nil
+	}
+	return policy, err
+}
+
+// twoFactorRequiredForRole reports whether the admin policy makes 2FA
+// mandatory for the role. If the policy cannot be read it errs on the safe
+// side for admins and managers.
+func twoFactorRequiredForRole(ctx context.Context, role string) bool {
+	policy, err := getTwoFactorPolicy(ctx)
+	if err != nil {
+		log.Printf("Error reading two-factor policy: %v", err)
+		return role == models.ROLE_ADMIN || role == models.ROLE_MANAGER
+	}
+
+	for _, requiredRole := range policy.Required_roles {
+		if requiredRole == role {
+			return true
+		}
+	}
+	return false
+}
+
+// getVoidPolicy returns the void policy, which requires a manager override
+// until an admin turns it off.
+func getVoidPolicy(ctx context.Context) (models.VoidPolicy, error) {
+	var policy models.VoidPolicy
+
+	err := settingCollection.FindOne(ctx, bson.M{"key": voidPolicyKey}).Decode(&policy)
+	if err == mongo.ErrNoDocuments {
+		return models.VoidPolicy{Key: voidPolicyKey, Require_override: true}, nil
+	}
+	return policy, err
+}
+
+func GetTwoFactorPolicy() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		policy, err := getTwoFactorPolicy(ctx)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching the two-factor policy"})
+			return
+		}
+		c.JSON(http.StatusOK, policy)
+	}
+}
+
+func UpdateTwoFactorPolicy() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var policy models.TwoFactorPolicy
+
+		if err := c.BindJSON(&policy); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		validationErr := validate.Struct(policy)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		if policy.Required_roles == nil {
+			policy.Required_roles = []string{}
+		}
+		policy.Key = twoFactorPolicyKey
+		policy.Updated_by = c.GetString("uid")
+		policy.Updated_at = time.Now()
+
+		upsert := true
+		opt := options.UpdateOptions{
+			Upsert: &upsert,
+		}
+
+		_, err := settingCollection.UpdateOne(
+			ctx,
+			bson.M{"key": twoFactorPolicyKey},
+			bson.M{"$set": bson.M{
+				"key":            policy.Key,
+				"required_roles": policy.Required_roles,
+				"updated_by":     policy.Updated_by,
+				"updated_at":     policy.Updated_at,
+			}},
+			&opt,
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "two-factor policy update failed"})
+			return
+		}
+
+		c.JSON(http.StatusOK, policy)
+	}
+}
+
+func GetVoidPolicy() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		policy, err := getVoidPolicy(ctx)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching the void policy"})
+			return
+		}
+		c.JSON(http.StatusOK, policy)
+	}
+}
+
+func UpdateVoidPolicy() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var policy models.VoidPolicy
+
+		if err := c.BindJSON(&policy); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		policy.Key = voidPolicyKey
+		policy.Updated_by = c.GetString("uid")
+		policy.Updated_at = time.Now()
+
+		upsert := true
+		opt := options.UpdateOptions{
+			Upsert: &upsert,
+		}
+
+		_, err := settingCollection.UpdateOne(
+			ctx,
+			bson.M{"key": voidPolicyKey},
+			bson.M{"$set": bson.M{
+				"key":              policy.Key,
+				"require_override": policy.Require_override,
+				"updated_by":       policy.Updated_by,
+				"updated_at":       policy.Updated_at,
+			}},
+			&opt,
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "void policy update failed"})
+			return
+		}
+
+		c.JSON(http.StatusOK, policy)
+	}
+}
@@ -0,0 +1,426 @@
+package controller
+
+import (
+	"context"
+	"golang-restaurant-management/database"
+	"golang-restaurant-management/models"
+	"golang-restaurant-management/tasks"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var stationCollection *mongo.Collection = database.OpenCollection(database.Client, "station")
+
+func GetStations() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		cursor, err := stationCollection.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"name": 1}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing stations"})
+			return
+		}
+
+		stations := []models.Station{}
+		if err = cursor.All(ctx, &stations); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing stations"})
+			return
+		}
+
+		c.JSON(http.StatusOK, stations)
+	}
+}
+
+func GetStation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var station models.Station
+		err := stationCollection.FindOne(ctx, bson.M{"station_id": c.Param("station_id")}).Decode(&station)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Station not found"})
+			} else {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while fetching the station"})
+			}
+			return
+		}
+
+		c.JSON(http.StatusOK, station)
+	}
+}
+
+func CreateStation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var station models.Station
+
+		if err := c.BindJSON(&station); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if validationErr := validate.Struct(station); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		station.Created_at = time.Now()
+		station.Updated_at = time.Now()
+		station.ID = primitive.NewObjectID()
+		station.Station_id = station.ID.Hex()
+
+		if _, err := stationCollection.InsertOne(ctx, station); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Station was not created"})
+			return
+		}
+
+		c.JSON(http.StatusOK, station)
+	}
+}
+
+func UpdateStation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var request struct {
+			Name *string `json:"name" validate:"required,min=2,max=50"`
+		}
+
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if validationErr := validate.Struct(request); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		var station models.Station
+		err := stationCollection.FindOneAndUpdate(
+			ctx,
+			bson.M{"station_id": c.Param("station_id")},
+			bson.M{"$set": bson.M{"name": request.Name, "updated_at": time.Now()}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&station)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Station not found"})
+			} else {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Station update failed"})
+			}
+			return
+		}
+
+		c.JSON(http.StatusOK, station)
+	}
+}
+
+// DeleteStation refuses to delete a station foods or menus are still routed
+// to, their items would silently stop showing up in any station queue.
+func DeleteStation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		stationId := c.Param("station_id")
+
+		foods, err := foodCollection.CountDocuments(ctx, bson.M{"station_id": stationId})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Station was not deleted"})
+			return
+		}
+		menus, err := menuCollection.CountDocuments(ctx, bson.M{"station_id": stationId})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Station was not deleted"})
+			return
+		}
+		if foods > 0 || menus > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "foods or menus are still assigned to this station"})
+			return
+		}
+
+		result, err := stationCollection.DeleteOne(ctx, bson.M{"station_id": stationId})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Station was not deleted"})
+			return
+		}
+		if result.DeletedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Station not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Station deleted successfully"})
+	}
+}
+
+// stationItem is an item in the queue of a station with the notes on it and
+// on its order.
+type stationItem struct {
+	models.OrderItem `bson:",inline"`
+	Notes            []models.Note `json:"notes"`
+	Order_notes      []models.Note `json:"order_notes"`
+}
+
+// GetStationItems lists the queue of a station, oldest first. Finished items
+// are left out unless asked for with ?prep_status=DONE, ?type=TAKEAWAY,...
+// limits the queue to orders of those types.
+func GetStationItems() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		statuses := []interface{}{models.PREP_QUEUED, models.PREP_COOKING, "", nil}
+		if query := c.Query("prep_status"); query != "" {
+			statuses = []interface{}{}
+			for _, status := range strings.Split(query, ",") {
+				status = strings.ToUpper(strings.TrimSpace(status))
+				if status != models.PREP_QUEUED && status != models.PREP_COOKING && status != models.PREP_DONE {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "prep_status must be QUEUED, COOKING or DONE"})
+					return
+				}
+				statuses = append(statuses, status)
+				if status == models.PREP_QUEUED {
+					statuses = append(statuses, "", nil)
+				}
+			}
+		}
+
+		typeFilter, ok := orderTypeFilter(c)
+		if !ok {
+			return
+		}
+
+		filter := bson.M{
+			"station_id":  c.Param("station_id"),
+			"prep_status": bson.M{"$in": statuses},
+			"void.type":   bson.M{"$ne": models.VOID_TYPE_VOID},
+		}
+		if err := filterByOrders(ctx, filter, typeFilter); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing station items"})
+			return
+		}
+
+		cursor, err := orderItemCollection.Find(ctx, filter, options.Find().SetSort(bson.M{"created_at": 1}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing station items"})
+			return
+		}
+
+		var orderItems []models.OrderItem
+		if err = cursor.All(ctx, &orderItems); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing station items"})
+			return
+		}
+
+		var orderItemIds, orderIds []string
+		for _, orderItem := range orderItems {
+			orderItemIds = append(orderItemIds, orderItem.Order_item_id)
+			orderIds = append(orderIds, orderItem.Order_id)
+		}
+
+		visibilities := noteVisibilities(c.GetString("role"))
+		itemNotes, err := notesByTarget(ctx, models.NOTE_TARGET_ORDER_ITEM, orderItemIds, visibilities)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing station items"})
+			return
+		}
+		orderNotes, err := notesByTarget(ctx, models.NOTE_TARGET_ORDER, orderIds, visibilities)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing station items"})
+			return
+		}
+
+		items := []stationItem{}
+		for _, orderItem := range orderItems {
+			items = append(items, stationItem{
+				OrderItem:   orderItem,
+				Notes:       append([]models.Note{}, itemNotes[orderItem.Order_item_id]...),
+				Order_notes: append([]models.Note{}, orderNotes[orderItem.Order_id]...),
+			})
+		}
+
+		c.JSON(http.StatusOK, items)
+	}
+}
+
+// BumpStationItem moves an item of the station on to its next prep status.
+// The first item to start cooking puts the order in preparation and the last
+// item to finish makes it READY, items without a station are not waited for.
+func BumpStationItem() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		stationId := c.Param("station_id")
+		orderItemId := c.Param("order_item_id")
+
+		var orderItem models.OrderItem
+		err := orderItemCollection.FindOne(ctx, bson.M{
+			"order_item_id": orderItemId,
+			"station_id":    stationId,
+			"void.type":     bson.M{"$ne": models.VOID_TYPE_VOID},
+		}).Decode(&orderItem)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Order item not found at this station"})
+			} else {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while fetching the order item"})
+			}
+			return
+		}
+
+		current := prepStatus(orderItem)
+		next, ok := models.PREP_NEXT[current]
+		if !ok {
+			c.JSON(http.StatusConflict, gin.H{"error": "the item is already done"})
+			return
+		}
+
+		now := time.Now()
+		update := bson.M{"prep_status": next, "updated_at": now}
+		if next == models.PREP_COOKING {
+			update["prep_started_at"] = now
+			orderItem.Prep_started_at = &now
+		} else {
+			update["prep_done_at"] = now
+			orderItem.Prep_done_at = &now
+		}
+
+		filter := bson.M{"order_item_id": orderItemId, "prep_status": current}
+		if current == models.PREP_QUEUED {
+			filter["prep_status"] = bson.M{"$in": []interface{}{models.PREP_QUEUED, "", nil}}
+		}
+
+		result, err := orderItemCollection.UpdateOne(ctx, filter, bson.M{"$set": update})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Order item update failed"})
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "the item was bumped by someone else"})
+			return
+		}
+
+		orderItem.Prep_status = next
+		orderItem.Updated_at = now
+
+		publishKitchenEvent(tasks.KitchenEvent{
+			Type:       tasks.KITCHEN_ORDER_ITEM_PREP_CHANGED,
+			Order_id:   orderItem.Order_id,
+			Station:    stationId,
+			Status:     next,
+			Order_item: orderItem,
+		})
+
+		if next == models.PREP_COOKING {
+			advanceOrder(ctx, orderItem.Order_id, models.ORDER_IN_PREPARATION, c.GetString("uid"))
+		} else {
+			advanceOrderIfPrepared(ctx, orderItem.Order_id, c.GetString("uid"))
+		}
+
+		c.JSON(http.StatusOK, orderItem)
+	}
+}
+
+// prepStatus returns the prep status of an item, items created before
+// stations existed count as QUEUED.
+func prepStatus(orderItem models.OrderItem) string {
+	if orderItem.Prep_status == "" {
+		return models.PREP_QUEUED
+	}
+	return orderItem.Prep_status
+}
+
+// advanceOrderIfPrepared makes an order READY once the stations finished all
+// of its items that were not voided.
+func advanceOrderIfPrepared(ctx context.Context, orderId string, actorId string) {
+	pending, err := orderItemCollection.CountDocuments(ctx, bson.M{
+		"order_id":    orderId,
+		"station_id":  bson.M{"$nin": []interface{}{nil, ""}},
+		"prep_status": bson.M{"$ne": models.PREP_DONE},
+		"void.type":   bson.M{"$ne": models.VOID_TYPE_VOID},
+	})
+	if err == nil && pending == 0 {
+		advanceOrder(ctx, orderId, models.ORDER_READY, actorId)
+	}
+}
+
+// advanceOrder walks an order the kitchen works on forward to status, through
+// IN_PREPARATION when needed. Orders that were not accepted yet or are
+// already past status are left alone.
+func advanceOrder(ctx context.Context, orderId string, status string, actorId string) {
+	var order models.Order
+	if err := orderCollection.FindOne(ctx, bson.M{"order_id": orderId}).Decode(&order); err != nil {
+		log.Printf("failed to load order %s to advance it: %v", orderId, err)
+		return
+	}
+
+	for _, step := range []string{models.ORDER_IN_PREPARATION, models.ORDER_READY} {
+		if models.CanTransition(orderStatus(order), step) {
+			var err error
+			order, err = transitionOrder(ctx, order, step, actorId, nil)
+			if err != nil {
+				log.Printf("failed to move order %s to %s: %v", orderId, step, err)
+				return
+			}
+			publishOrderStatus(order)
+		}
+		if step == status {
+			return
+		}
+	}
+}
+
+// kitchenStation returns the station that prepares a food, its own station
+// or else the station of its menu.
+func kitchenStation(ctx context.Context, food models.Food) string {
+	if food.Station_id != nil && *food.Station_id != "" {
+		return *food.Station_id
+	}
+	if food.Menu_id == nil {
+		return ""
+	}
+
+	var menu models.Menu
+	if err := menuCollection.FindOne(ctx, bson.M{"menu_id": *food.Menu_id}).Decode(&menu); err != nil {
+		return ""
+	}
+	if menu.Station_id == nil {
+		return ""
+	}
+	return *menu.Station_id
+}
+
+// checkStation reports whether stationId names a station, answering the
+// request itself when it does not. An empty id clears the assignment.
+func checkStation(ctx context.Context, c *gin.Context, stationId *string) bool {
+	if stationId == nil || *stationId == "" {
+		return true
+	}
+
+	count, err := stationCollection.CountDocuments(ctx, bson.M{"station_id": *stationId})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while finding station"})
+		return false
+	}
+	if count == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Station not found"})
+		return false
+	}
+	return true
+}
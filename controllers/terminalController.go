@@ -0,0 +1,165 @@
+package controller
+
+import (
+	"context"
+	helper "golang-restaurant-management/helpers"
+	"golang-restaurant-management/models"
+	"golang-restaurant-management/tasks"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// GetTerminalStaff lists the staff that can log in with a PIN, so the
+// terminal can show a picker instead of asking for an email. Managers keep
+// a PIN to approve voids but are not listed, see pinLoginAllowed.
+func GetTerminalStaff() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		filter := bson.M{"pin": bson.M{"$ne": nil}, "is_verified": true, "is_deactivated": bson.M{"$ne": true}}
+		opts := options.Find().
+			SetProjection(bson.M{"_id": 0, "user_id": 1, "first_name": 1, "last_name": 1, "role": 1}).
+			SetSort(bson.M{"first_name": 1})
+
+		result, err := userCollection.Find(ctx, filter, opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing staff"})
+			return
+		}
+
+		var users []bson.M
+		if err = result.All(ctx, &users); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing staff"})
+			return
+		}
+
+		staff := []bson.M{}
+		for _, user := range users {
+			role, _ := user["role"].(string)
+			if role == "" {
+				role = models.ROLE_WAITER
+			}
+			if pinLoginAllowed(ctx, role) {
+				staff = append(staff, user)
+			}
+		}
+		c.JSON(http.StatusOK, staff)
+	}
+}
+
+// PinLogin logs a member of floor staff in on a registered terminal. The
+// token it hands out only covers floor operations and locks after
+// PIN_IDLE_TIMEOUT without requests.
+func PinLogin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var loginData struct {
+			User_id string `json:"user_id" binding:"required"`
+			Pin     string `json:"pin" binding:"required"`
+		}
+
+		if err := c.BindJSON(&loginData); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if isThrottled(c, "pin", loginData.User_id) {
+			return
+		}
+
+		var foundUser models.User
+		err := userCollection.FindOne(ctx, bson.M{"user_id": loginData.User_id}).Decode(&foundUser)
+		pinIsValid := false
+		if err == nil && foundUser.Pin != nil {
+			pinIsValid, _ = VerifyPassword(loginData.Pin, *foundUser.Pin)
+		}
+
+		if !pinIsValid {
+			if registerFailedAttempt(c, "pin", loginData.User_id) {
+				return
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "user or PIN is incorrect"})
+			return
+		}
+
+		resetAttempts("pin", loginData.User_id)
+
+		if foundUser.Is_deactivated {
+			c.JSON(http.StatusForbidden, gin.H{"error": "this account has been deactivated"})
+			return
+		}
+
+		if !foundUser.Is_Verified {
+			c.JSON(http.StatusForbidden, gin.H{"error": "user not verified"})
+			return
+		}
+
+		role := userRole(foundUser)
+		if !pinLoginAllowed(ctx, role) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "a full login is required for the " + role + " role"})
+			return
+		}
+
+		token, claims, err := helper.GeneratePinToken(*foundUser.Email, *foundUser.First_name, *foundUser.Last_name, foundUser.User_id, role, c.GetString("terminal_id"), foundUser.Token_version)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+			return
+		}
+
+		if err := tasks.StartTerminalActivity(claims.ID, helper.PIN_IDLE_TIMEOUT); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start the terminal session"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"token":        token,
+			"user_id":      foundUser.User_id,
+			"first_name":   foundUser.First_name,
+			"last_name":    foundUser.Last_name,
+			"role":         role,
+			"terminal_id":  claims.Terminal_id,
+			"scopes":       claims.Scopes,
+			"expires_at":   claims.ExpiresAt.Unix(),
+			"idle_timeout": int(helper.PIN_IDLE_TIMEOUT.Seconds()),
+		})
+	}
+}
+
+// pinLoginAllowed keeps PIN logins to floor staff. A PIN is no second factor,
+// so admins, managers and every role the 2FA policy covers need a full login.
+func pinLoginAllowed(ctx context.Context, role string) bool {
+	if role == models.ROLE_ADMIN || role == models.ROLE_MANAGER {
+		return false
+	}
+	return !twoFactorRequiredForRole(ctx, role)
+}
+
+// LockTerminal ends the PIN login on the terminal right away, e.g. when the
+// waiter hands the tablet over.
+func LockTerminal() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("token_type") != helper.PIN_TOKEN || c.GetString("terminal_id") != c.Param("terminal_id") {
+			c.JSON(http.StatusForbidden, gin.H{"error": "only a PIN login on this terminal can be locked"})
+			return
+		}
+
+		expiresAt := time.Unix(c.GetInt64("expires_at"), 0)
+		if err := tasks.RevokeToken(c.GetString("jti"), time.Until(expiresAt)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to lock the terminal"})
+			return
+		}
+		if err := tasks.EndTerminalActivity(c.GetString("jti")); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to lock the terminal"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Terminal locked"})
+	}
+}
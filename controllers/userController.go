@@ -8,8 +8,12 @@ import (
 	"golang-restaurant-management/models"
 	"golang-restaurant-management/tasks"
 	"log"
+	"math"
 	"net/http"
+	"os"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -17,15 +21,97 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"golang.org/x/crypto/bcrypt"
 )
 
 var userCollection *mongo.Collection = database.OpenCollection(database.Client, "user")
 var Validate = validator.New()
 
+// UserViewFormat is how users are shown by the API. Password, tokens, OTPs,
+// the PIN and 2FA secrets never leave the server, the queries only load the
+// fields in userViewProjection.
+type UserViewFormat struct {
+	User_id        string     `json:"user_id"`
+	First_name     *string    `json:"first_name"`
+	Last_name      *string    `json:"last_name"`
+	Email          *string    `json:"email"`
+	Phone          *string    `json:"phone"`
+	Avatar         *string    `json:"avatar"`
+	Role           *string    `json:"role"`
+	Is_Verified    bool       `json:"is_verified"`
+	Totp_enabled   bool       `json:"totp_enabled"`
+	Has_pin        bool       `json:"has_pin"`
+	Is_deactivated bool       `json:"is_deactivated"`
+	Deactivated_at *time.Time `json:"deactivated_at"`
+	Erased_at      *time.Time `json:"erased_at"`
+	Created_at     time.Time  `json:"created_at"`
+	Updated_at     time.Time  `json:"updated_at"`
+}
+
+var userViewProjection = bson.M{
+	"_id":            0,
+	"user_id":        1,
+	"first_name":     1,
+	"last_name":      1,
+	"email":          1,
+	"phone":          1,
+	"avatar":         1,
+	"role":           1,
+	"is_verified":    1,
+	"totp_enabled":   1,
+	"has_pin":        bson.M{"$gt": bson.A{"$pin", nil}},
+	"is_deactivated": 1,
+	"deactivated_at": 1,
+	"erased_at":      1,
+	"created_at":     1,
+	"updated_at":     1,
+}
+
+// newUserView builds the view of a user that was loaded in full.
+func newUserView(user models.User) UserViewFormat {
+	role := userRole(user)
+	return UserViewFormat{
+		User_id:        user.User_id,
+		First_name:     user.First_name,
+		Last_name:      user.Last_name,
+		Email:          user.Email,
+		Phone:          user.Phone,
+		Avatar:         user.Avatar,
+		Role:           &role,
+		Is_Verified:    user.Is_Verified,
+		Totp_enabled:   user.Totp_enabled,
+		Has_pin:        user.Pin != nil,
+		Is_deactivated: user.Is_deactivated,
+		Deactivated_at: user.Deactivated_at,
+		Erased_at:      user.Erased_at,
+		Created_at:     user.Created_at,
+		Updated_at:     user.Updated_at,
+	}
+}
+
+// withDefaultRole fills in the role users created before roles existed have.
+func (userView *UserViewFormat) withDefaultRole() {
+	if userView.Role == nil || *userView.Role == "" {
+		role := models.ROLE_WAITER
+		userView.Role = &role
+	}
+}
+
+func findUserView(ctx context.Context, userId string) (UserViewFormat, error) {
+	var userView UserViewFormat
+	opts := options.FindOne().SetProjection(userViewProjection)
+	err := userCollection.FindOne(ctx, bson.M{"user_id": userId}, opts).Decode(&userView)
+	userView.withDefaultRole()
+	return userView, err
+}
+
+// GetUsers lists users a page at a time. It can search the name and email
+// with ?search=, and filter with ?role= and ?status=active|deactivated.
 func GetUsers() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
 
 		recordPerPage, err := strconv.Atoi(c.Query("recordPerPage"))
 		if err != nil || recordPerPage < 1 {
@@ -38,46 +124,99 @@ func GetUsers() gin.HandlerFunc {
 		}
 
 		startIndex := (page - 1) * recordPerPage
-		startIndex, err = strconv.Atoi(c.Query("startIndex"))
+		if c.Query("startIndex") != "" {
+			startIndex, err = strconv.Atoi(c.Query("startIndex"))
+			if err != nil || startIndex < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "startIndex must be a positive number"})
+				return
+			}
+		}
+
+		filter := bson.M{}
+		if search := strings.TrimSpace(c.Query("search")); search != "" {
+			pattern := primitive.Regex{Pattern: regexp.QuoteMeta(search), Options: "i"}
+			filter["$or"] = bson.A{
+				bson.M{"first_name": pattern},
+				bson.M{"last_name": pattern},
+				bson.M{"email": pattern},
+			}
+		}
+		if role := c.Query("role"); role != "" {
+			if role == models.ROLE_WAITER {
+				filter["role"] = bson.M{"$in": bson.A{role, nil}}
+			} else {
+				filter["role"] = role
+			}
+		}
+		switch c.Query("status") {
+		case "":
+		case "active":
+			filter["is_deactivated"] = bson.M{"$ne": true}
+		case "deactivated":
+			filter["is_deactivated"] = true
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "status must be active or deactivated"})
+			return
+		}
 
-		matchStage := bson.D{{"$match", bson.D{{}}}}
+		matchStage := bson.D{{Key: "$match", Value: filter}}
+		sortStage := bson.D{{Key: "$sort", Value: bson.D{{Key: "created_at", Value: 1}}}}
+		projectUserStage := bson.D{{Key: "$project", Value: userViewProjection}}
+		groupStage := bson.D{{Key: "$group", Value: bson.D{{Key: "_id", Value: nil}, {Key: "total_count", Value: bson.D{{Key: "$sum", Value: 1}}}, {Key: "data", Value: bson.D{{Key: "$push", Value: "$$ROOT"}}}}}}
 		projectStage := bson.D{
-			{"$project", bson.D{
-				{"_id", 0},
-				{"total_count", 1},
-				{"user_items", bson.D{{"$slice", []interface{}{"$data", startIndex, recordPerPage}}}},
+			{Key: "$project", Value: bson.D{
+				{Key: "_id", Value: 0},
+				{Key: "total_count", Value: 1},
+				{Key: "user_items", Value: bson.D{{Key: "$slice", Value: []interface{}{"$data", startIndex, recordPerPage}}}},
 			}}}
 
 		result, err := userCollection.Aggregate(ctx, mongo.Pipeline{
-			matchStage, projectStage})
-		defer cancel()
+			matchStage, sortStage, projectUserStage, groupStage, projectStage})
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing user items"})
+			return
 		}
 
-		var allUsers []bson.M
+		var allUsers []struct {
+			Total_count int              `json:"total_count"`
+			User_items  []UserViewFormat `json:"user_items"`
+		}
 		if err = result.All(ctx, &allUsers); err != nil {
-			log.Fatal(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing user items"})
+			return
+		}
+
+		// Without any match the group stage outputs nothing
+		if len(allUsers) == 0 {
+			c.JSON(http.StatusOK, gin.H{"total_count": 0, "user_items": []UserViewFormat{}})
+			return
 		}
-		c.JSON(http.StatusOK, allUsers[0])
 
+		for i := range allUsers[0].User_items {
+			allUsers[0].User_items[i].withDefaultRole()
+		}
+		if allUsers[0].User_items == nil {
+			allUsers[0].User_items = []UserViewFormat{}
+		}
+		c.JSON(http.StatusOK, allUsers[0])
 	}
 }
 
 func GetUser() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
-		userId := c.Param("user_id")
-
-		var user models.User
-
-		err := userCollection.FindOne(ctx, bson.M{"user_id": userId}).Decode(&user)
-
 		defer cancel()
+
+		userView, err := findUserView(ctx, c.Param("user_id"))
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing user items"})
+			return
 		}
-		c.JSON(http.StatusOK, user)
+		c.JSON(http.StatusOK, userView)
 	}
 }
 
@@ -98,6 +237,13 @@ func SignUp() gin.HandlerFunc {
 			return
 		}
 
+		// With open signup disabled only the bootstrap admin can sign up,
+		// everyone else needs an invitation
+		if !isSignupOpen() && !isBootstrapAdmin(ctx, *user.Email) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "signup is disabled, ask an admin for an invitation"})
+			return
+		}
+
 		count, err := userCollection.CountDocuments(ctx, bson.M{"email": user.Email})
 		if err != nil {
 			log.Panic(err)
@@ -114,20 +260,33 @@ func SignUp() gin.HandlerFunc {
 		password := HashPassword(*user.Password)
 		user.Password = &password
 
+		// Roles are never taken from the signup payload
+		role := models.ROLE_WAITER
+		if isBootstrapAdmin(ctx, *user.Email) {
+			role = models.ROLE_ADMIN
+		}
+		user.Role = &role
+		user.Totp_enabled = false
+		user.Is_Verified = false
+
 		// Generate OTP
-		otp, err := tasks.GenerateOTP(*user.Email)
+		otp, err := tasks.GenerateOTP()
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate OTP"})
 			return
 		}
 
 		// Store OTP
-		err = tasks.StoreOTP(*user.Email, otp)
+		err = tasks.StoreOTP(tasks.OTP_PURPOSE_VERIFY, *user.Email, otp)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store OTP"})
 			return
 		}
 
+		if _, err := tasks.StartOTPCooldown(tasks.OTP_PURPOSE_VERIFY, *user.Email); err != nil {
+			log.Printf("Error starting OTP cooldown: %v", err)
+		}
+
 		// Queue verification email
 		err = tasks.QueueVerificationEmail(*user.Email, otp)
 		if err != nil {
@@ -156,6 +315,7 @@ func SignUp() gin.HandlerFunc {
 func Login() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
 		var user models.User
 		var foundUser models.User
 
@@ -164,273 +324,1760 @@ func Login() gin.HandlerFunc {
 			return
 		}
 
+		if user.Email == nil || user.Password == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "email and password are required"})
+			return
+		}
+
+		if isThrottled(c, "login", *user.Email) {
+			return
+		}
+
 		err := userCollection.FindOne(ctx, bson.M{"email": user.Email}).Decode(&foundUser)
-		defer cancel()
 		if err != nil {
+			if registerFailedAttempt(c, "login", *user.Email) {
+				return
+			}
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "user not found, login seems to be incorrect"})
 			return
 		}
 
 		passwordIsValid, msg := VerifyPassword(*user.Password, *foundUser.Password)
-		defer cancel()
 		if !passwordIsValid {
+			if registerFailedAttempt(c, "login", *user.Email) {
+				return
+			}
 			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
 			return
 		}
 
+		resetAttempts("login", *user.Email)
+
+		if foundUser.Is_deactivated {
+			c.JSON(http.StatusForbidden, gin.H{"error": "this account has been deactivated"})
+			return
+		}
+
 		if !foundUser.Is_Verified {
 			c.JSON(http.StatusForbidden, gin.H{"error": "user not verified"})
 			return
 		}
-		token, refreshToken, _ := helper.GenerateAllTokens(*foundUser.Email, *foundUser.First_name, *foundUser.Last_name, foundUser.User_id)
 
-		helper.UpdateAllTokens(token, refreshToken, foundUser.User_id)
+		role := userRole(foundUser)
+		if role != models.ROLE_ADMIN && isBootstrapAdmin(ctx, *foundUser.Email) {
+			role = models.ROLE_ADMIN
+			_, err = userCollection.UpdateOne(ctx, bson.M{"user_id": foundUser.User_id}, bson.M{"$set": bson.M{"role": role}})
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to assign bootstrap admin role"})
+				return
+			}
+		}
+		foundUser.Role = &role
+
+		respondWithSecondFactorOrSession(ctx, c, foundUser)
+	}
+}
 
-		// Create a new struct to hold the response data
-		response := struct {
-			ID            primitive.ObjectID `json:"id"`
-			First_name    *string            `json:"first_name"`
-			Last_name     *string            `json:"last_name"`
-			Email         *string            `json:"email"`
-			Phone         *string            `json:"phone"`
-			Token         *string            `json:"token"`
-			Refresh_Token *string            `json:"refresh_token"`
-			User_id       string             `json:"user_id"`
-			Is_Verified   bool               `json:"is_verified"`
-		}{
-			ID:            foundUser.ID,
-			First_name:    foundUser.First_name,
-			Last_name:     foundUser.Last_name,
-			Email:         foundUser.Email,
-			Phone:         foundUser.Phone,
-			Token:         foundUser.Token,
-			Refresh_Token: foundUser.Refresh_Token,
-			User_id:       foundUser.User_id,
-			Is_Verified:   foundUser.Is_Verified,
+// respondWithSecondFactorOrSession finishes the first step of a login. Users
+// with 2FA get a challenge token for the second step, users whose role needs
+// 2FA but who have not enrolled get an enrollment token, everybody else a new
+// session.
+func respondWithSecondFactorOrSession(ctx context.Context, c *gin.Context, foundUser models.User) {
+	if foundUser.Totp_enabled {
+		challengeToken, err := helper.GenerateChallengeToken(*foundUser.Email, foundUser.User_id, helper.TWO_FACTOR_CHALLENGE_TOKEN, foundUser.Token_version)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create two-factor challenge"})
+			return
 		}
+		c.JSON(http.StatusOK, gin.H{"two_factor_required": true, "challenge_token": challengeToken})
+		return
+	}
 
-		c.JSON(http.StatusOK, response)
+	if twoFactorRequiredForRole(ctx, userRole(foundUser)) {
+		enrollmentToken, err := helper.GenerateChallengeToken(*foundUser.Email, foundUser.User_id, helper.TWO_FACTOR_ENROLLMENT_TOKEN, foundUser.Token_version)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create two-factor enrollment"})
+			return
+		}
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":                          "two-factor authentication is required for your role, please enroll",
+			"two_factor_enrollment_required": true,
+			"enrollment_token":               enrollmentToken,
+		})
+		return
 	}
+
+	respondWithNewSession(c, foundUser)
 }
 
-// func Login() gin.HandlerFunc {
-// 	return func(c *gin.Context) {
+// respondWithNewSession starts a session for the fully authenticated user and
+// responds with the user and their new token pair.
+func respondWithNewSession(c *gin.Context, foundUser models.User) {
+	role := userRole(foundUser)
+	foundUser.Role = &role
 
-// 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
-// 		var user models.User
-// 		var foundUser models.User
+	sessionId := primitive.NewObjectID()
+	token, refreshToken, _ := helper.GenerateAllTokens(*foundUser.Email, *foundUser.First_name, *foundUser.Last_name, foundUser.User_id, role, sessionId.Hex(), foundUser.Token_version)
 
-// 		//convert the login data from postman which is in JSON to golang readable format
+	err := helper.CreateSession(sessionId, foundUser.User_id, refreshToken, c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
+		return
+	}
 
-// 		if err := c.BindJSON(&user); err != nil {
-// 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-// 			return
-// 		}
+	// Create a new struct to hold the response data
+	response := struct {
+		ID            primitive.ObjectID `json:"id"`
+		First_name    *string            `json:"first_name"`
+		Last_name     *string            `json:"last_name"`
+		Email         *string            `json:"email"`
+		Phone         *string            `json:"phone"`
+		Role          *string            `json:"role"`
+		Token         *string            `json:"token"`
+		Refresh_Token *string            `json:"refresh_token"`
+		Session_id    string             `json:"session_id"`
+		User_id       string             `json:"user_id"`
+		Is_Verified   bool               `json:"is_verified"`
+		Totp_enabled  bool               `json:"totp_enabled"`
+	}{
+		ID:            foundUser.ID,
+		First_name:    foundUser.First_name,
+		Last_name:     foundUser.Last_name,
+		Email:         foundUser.Email,
+		Phone:         foundUser.Phone,
+		Role:          foundUser.Role,
+		Token:         &token,
+		Refresh_Token: &refreshToken,
+		Session_id:    sessionId.Hex(),
+		User_id:       foundUser.User_id,
+		Is_Verified:   foundUser.Is_Verified,
+		Totp_enabled:  foundUser.Totp_enabled,
+	}
 
-// 		//find a user with that email and see if that user even exists
+	c.JSON(http.StatusOK, response)
+}
 
-// 		err := userCollection.FindOne(ctx, bson.M{"email": user.Email}).Decode(&foundUser)
-// 		defer cancel()
-// 		if err != nil {
-// 			c.JSON(http.StatusInternalServerError, gin.H{"error": "user not found, login seems to be incorrect"})
-// 			return
-// 		}
+func LoginTwoFactor() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
 
-// 		//then you will verify the password
+		var challengeData struct {
+			Challenge_token string `json:"challenge_token" binding:"required"`
+			Code            string `json:"code"`
+			Recovery_code   string `json:"recovery_code"`
+		}
 
-// 		passwordIsValid, msg := VerifyPassword(*user.Password, *foundUser.Password)
-// 		defer cancel()
-// 		if passwordIsValid != true {
-// 			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
-// 			return
-// 		}
+		if err := c.BindJSON(&challengeData); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 
-// 		//if all goes well, then you'll generate tokens
+		claims, msg := helper.ValidateToken(challengeData.Challenge_token)
+		if msg != "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": msg})
+			return
+		}
 
-// 		token, refreshToken, _ := helper.GenerateAllTokens(*foundUser.Email, *foundUser.First_name, *foundUser.Last_name, foundUser.User_id)
+		if claims.Token_type != helper.TWO_FACTOR_CHALLENGE_TOKEN {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "a two-factor challenge token is required"})
+			return
+		}
 
-// 		//update tokens - token and refersh token
-// 		helper.UpdateAllTokens(token, refreshToken, foundUser.User_id)
+		if isThrottled(c, "2fa", claims.Email) {
+			return
+		}
 
-// 		//return statusOK
-// 		c.JSON(http.StatusOK, foundUser)
-// 	}
-// }
+		var foundUser models.User
+		err := userCollection.FindOne(ctx, bson.M{"user_id": claims.Uid}).Decode(&foundUser)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "user not found"})
+			return
+		}
 
-func HashPassword(password string) string {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
-	if err != nil {
-		log.Panic(err)
+		valid, err := verifySecondFactor(ctx, foundUser, challengeData.Code, challengeData.Recovery_code)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify two-factor code"})
+			return
+		}
+
+		if !valid {
+			if registerFailedAttempt(c, "2fa", claims.Email) {
+				return
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid two-factor code"})
+			return
+		}
+
+		resetAttempts("2fa", claims.Email)
+
+		// Challenge tokens are single use
+		if err := helper.RevokeToken(claims); err != nil {
+			log.Printf("Error revoking challenge token: %v", err)
+		}
+
+		respondWithNewSession(c, foundUser)
 	}
+}
 
-	return string(bytes)
+// verifySecondFactor checks either a TOTP code or a recovery code. Both are
+// single use: the TOTP time step is recorded and recovery codes are removed.
+func verifySecondFactor(ctx context.Context, user models.User, code string, recoveryCode string) (bool, error) {
+	if !user.Totp_enabled || user.Totp_secret == nil {
+		return false, nil
+	}
+
+	if recoveryCode != "" {
+		result, err := userCollection.UpdateOne(
+			ctx,
+			bson.M{"user_id": user.User_id, "recovery_codes": helper.HashRecoveryCode(recoveryCode)},
+			bson.M{"$pull": bson.M{"recovery_codes": helper.HashRecoveryCode(recoveryCode)}},
+		)
+		if err != nil {
+			return false, err
+		}
+		return result.MatchedCount == 1, nil
+	}
+
+	return consumeTOTP(ctx, user, code)
 }
 
-func VerifyPassword(userPassword string, providedPassword string) (bool, string) {
+// consumeTOTP validates the code against the secret of the user and records
+// its time step so that the same code cannot be used twice.
+func consumeTOTP(ctx context.Context, user models.User, code string) (bool, error) {
+	if user.Totp_secret == nil {
+		return false, nil
+	}
 
-	err := bcrypt.CompareHashAndPassword([]byte(providedPassword), []byte(userPassword))
-	check := true
-	msg := ""
+	step, ok := helper.ValidateTOTP(*user.Totp_secret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
 
+	result, err := userCollection.UpdateOne(
+		ctx,
+		bson.M{"user_id": user.User_id, "totp_last_step": bson.M{"$not": bson.M{"$gte": step}}},
+		bson.M{"$set": bson.M{"totp_last_step": step}},
+	)
 	if err != nil {
-		msg = fmt.Sprintf("login or password is incorrect")
-		check = false
+		return false, err
 	}
-	return check, msg
+	return result.MatchedCount == 1, nil
 }
 
-func VerifyOTP() gin.HandlerFunc {
+func EnrollTwoFactor() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 
-		var verificationData struct {
-			Email string `json:"email" binding:"required,email"`
-			OTP   string `json:"otp" binding:"required"`
+		var foundUser models.User
+		err := userCollection.FindOne(ctx, bson.M{"user_id": c.GetString("uid")}).Decode(&foundUser)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
 		}
 
-		if err := c.BindJSON(&verificationData); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		if foundUser.Totp_enabled {
+			c.JSON(http.StatusConflict, gin.H{"error": "two-factor authentication is already enabled"})
 			return
 		}
 
-		// Retrieve stored OTP
-		storedOTP, err := tasks.GetStoredOTP(verificationData.Email)
+		secret, err := helper.GenerateTOTPSecret()
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve OTP"})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate two-factor secret"})
 			return
 		}
 
-		// Compare OTPs
-		if verificationData.OTP != storedOTP {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid OTP"})
+		recoveryCodes, recoveryHashes, err := helper.GenerateRecoveryCodes()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate recovery codes"})
 			return
 		}
 
-		// Update user verification status
+		// Stored but not enabled until the first code is confirmed
 		update := bson.M{
 			"$set": bson.M{
-				"is_verified":  true,
-				"is_otp_valid": false,
+				"totp_secret":    secret,
+				"totp_enabled":   false,
+				"totp_last_step": 0,
+				"recovery_codes": recoveryHashes,
+				"updated_at":     time.Now(),
 			},
 		}
 
-		_, err = userCollection.UpdateOne(
-			ctx,
-			bson.M{"email": verificationData.Email},
-			update,
-		)
-
+		_, err = userCollection.UpdateOne(ctx, bson.M{"user_id": foundUser.User_id}, update)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user verification status"})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store two-factor secret"})
 			return
 		}
 
-		// Clear stored OTP
-		err = tasks.ClearStoredOTP(verificationData.Email)
-		if err != nil {
-			log.Printf("Error clearing stored OTP: %v", err)
-		}
-
-		c.JSON(http.StatusOK, gin.H{"message": "OTP verified successfully"})
+		c.JSON(http.StatusOK, gin.H{
+			"secret":         secret,
+			"otpauth_uri":    helper.TOTPURI(secret, *foundUser.Email),
+			"recovery_codes": recoveryCodes,
+			"message":        "Scan the QR code with your authenticator app and confirm with a code to enable two-factor authentication",
+		})
 	}
 }
 
-func ForgotPassword() gin.HandlerFunc {
+func ConfirmTwoFactor() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
-		var user models.User
 
-		if err := c.BindJSON(&user); err != nil {
+		var confirmData struct {
+			Code string `json:"code" binding:"required"`
+		}
+
+		if err := c.BindJSON(&confirmData); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
-		// Check if user exists
 		var foundUser models.User
-		err := userCollection.FindOne(ctx, bson.M{"email": user.Email}).Decode(&foundUser)
+		err := userCollection.FindOne(ctx, bson.M{"user_id": c.GetString("uid")}).Decode(&foundUser)
 		if err != nil {
 			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 			return
 		}
 
-		// Generate OTP
-		otp, err := tasks.GenerateOTP(*user.Email)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate OTP"})
+		if foundUser.Totp_enabled {
+			c.JSON(http.StatusConflict, gin.H{"error": "two-factor authentication is already enabled"})
 			return
 		}
 
-		// Store OTP
-		err = tasks.StoreOTP(*user.Email, otp)
+		if foundUser.Totp_secret == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "two-factor enrollment has not been started"})
+			return
+		}
+
+		if isThrottled(c, "2fa", *foundUser.Email) {
+			return
+		}
+
+		valid, err := consumeTOTP(ctx, foundUser, confirmData.Code)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store OTP"})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify two-factor code"})
 			return
 		}
 
-		// Queue reset password email
-		err = tasks.QueueResetPasswordEmail(*user.Email, otp)
+		if !valid {
+			if registerFailedAttempt(c, "2fa", *foundUser.Email) {
+				return
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid two-factor code"})
+			return
+		}
+
+		resetAttempts("2fa", *foundUser.Email)
+
+		_, err = userCollection.UpdateOne(ctx, bson.M{"user_id": foundUser.User_id}, bson.M{"$set": bson.M{"totp_enabled": true, "updated_at": time.Now()}})
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue reset password email"})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enable two-factor authentication"})
+			return
+		}
+		foundUser.Totp_enabled = true
+
+		// Enrolling with an enrollment token completes the pending login
+		if c.GetString("token_type") == helper.TWO_FACTOR_ENROLLMENT_TOKEN {
+			expiresAt := time.Unix(c.GetInt64("expires_at"), 0)
+			if err := tasks.RevokeToken(c.GetString("jti"), time.Until(expiresAt)); err != nil {
+				log.Printf("Error revoking enrollment token: %v", err)
+			}
+			respondWithNewSession(c, foundUser)
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"message": "Reset password OTP sent to your email"})
+		c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication enabled successfully"})
 	}
 }
 
-func ResetPassword() gin.HandlerFunc {
+func DisableTwoFactor() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
-		var resetData struct {
-			Email       string `json:"email" binding:"required,email"`
-			OTP         string `json:"otp" binding:"required"`
-			NewPassword string `json:"new_password" binding:"required,min=6"`
+
+		var disableData struct {
+			Password      string `json:"password" binding:"required"`
+			Code          string `json:"code"`
+			Recovery_code string `json:"recovery_code"`
 		}
 
-		if err := c.BindJSON(&resetData); err != nil {
+		if err := c.BindJSON(&disableData); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
-		// Verify OTP
-		storedOTP, err := tasks.GetStoredOTP(resetData.Email)
+		var foundUser models.User
+		err := userCollection.FindOne(ctx, bson.M{"user_id": c.GetString("uid")}).Decode(&foundUser)
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired OTP"})
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 			return
 		}
 
-		if resetData.OTP != storedOTP {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid OTP"})
+		if !foundUser.Totp_enabled {
+			c.JSON(http.StatusConflict, gin.H{"error": "two-factor authentication is not enabled"})
 			return
 		}
 
-		// Hash new password
-		hashedPassword := HashPassword(resetData.NewPassword)
+		if twoFactorRequiredForRole(ctx, userRole(foundUser)) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "two-factor authentication is required for your role"})
+			return
+		}
 
-		// Update password in database
-		update := bson.M{
-			"$set": bson.M{
-				"password": hashedPassword,
-			},
+		if isThrottled(c, "2fa", *foundUser.Email) {
+			return
 		}
 
-		_, err = userCollection.UpdateOne(
-			ctx,
-			bson.M{"email": resetData.Email},
-			update,
-		)
+		passwordIsValid, _ := VerifyPassword(disableData.Password, *foundUser.Password)
+		valid := false
+		if passwordIsValid {
+			valid, err = verifySecondFactor(ctx, foundUser, disableData.Code, disableData.Recovery_code)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify two-factor code"})
+				return
+			}
+		}
 
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update password"})
+		if !valid {
+			if registerFailedAttempt(c, "2fa", *foundUser.Email) {
+				return
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid password or two-factor code"})
 			return
 		}
 
-		// Clear stored OTP
-		tasks.ClearStoredOTP(resetData.Email)
+		resetAttempts("2fa", *foundUser.Email)
 
-		c.JSON(http.StatusOK, gin.H{"message": "Password reset successfully"})
+		if err := clearTwoFactor(ctx, foundUser.User_id); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable two-factor authentication"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled successfully"})
 	}
 }
+
+// ResetTwoFactor lets an admin remove the second factor of a user who lost
+// their authenticator and recovery codes.
+func ResetTwoFactor() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		userId := c.Param("user_id")
+
+		count, err := userCollection.CountDocuments(ctx, bson.M{"user_id": userId})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while finding user"})
+			return
+		}
+		if count == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+
+		if err := clearTwoFactor(ctx, userId); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset two-factor authentication"})
+			return
+		}
+
+		helper.RecordAudit(c.GetString("uid"), models.AUDIT_TWO_FACTOR_RESET, "user", userId, c.ClientIP(), nil)
+
+		c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication reset successfully"})
+	}
+}
+
+func clearTwoFactor(ctx context.Context, userId string) error {
+	update := bson.M{
+		"$set": bson.M{
+			"totp_enabled": false,
+			"updated_at":   time.Now(),
+		},
+		"$unset": bson.M{
+			"totp_secret":    "",
+			"totp_last_step": "",
+			"recovery_codes": "",
+		},
+	}
+
+	_, err := userCollection.UpdateOne(ctx, bson.M{"user_id": userId}, update)
+	return err
+}
+
+func RefreshToken() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var refreshData struct {
+			Refresh_token string `json:"refresh_token" binding:"required"`
+		}
+
+		if err := c.BindJSON(&refreshData); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		claims, msg := helper.ValidateToken(refreshData.Refresh_token)
+		if msg != "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": msg})
+			return
+		}
+
+		if claims.Token_type != helper.REFRESH_TOKEN || claims.Uid == "" || claims.Session_id == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "a refresh token is required"})
+			return
+		}
+
+		var foundUser models.User
+		err := userCollection.FindOne(ctx, bson.M{"user_id": claims.Uid}).Decode(&foundUser)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "user not found"})
+			return
+		}
+
+		if !foundUser.Is_Verified {
+			c.JSON(http.StatusForbidden, gin.H{"error": "user not verified"})
+			return
+		}
+
+		token, refreshToken, _ := helper.GenerateAllTokens(*foundUser.Email, *foundUser.First_name, *foundUser.Last_name, foundUser.User_id, userRole(foundUser), claims.Session_id, foundUser.Token_version)
+
+		rotated, err := helper.RotateSessionToken(claims.Session_id, refreshData.Refresh_token, refreshToken, c.ClientIP())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rotate tokens"})
+			return
+		}
+
+		if !rotated {
+			// A valid but no longer current refresh token of a live session
+			// means it was stolen or replayed, so the whole family is revoked.
+			exists, err := helper.SessionExists(claims.Session_id)
+			if err != nil {
+				log.Printf("Error finding session: %v", err)
+			}
+			if exists {
+				log.Printf("Refresh token reuse detected for user %s, revoking session %s", foundUser.User_id, claims.Session_id)
+				if err := helper.RevokeSession(claims.Session_id); err != nil {
+					log.Printf("Error revoking session: %v", err)
+				}
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "refresh token has already been used"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"token":         token,
+			"refresh_token": refreshToken,
+		})
+	}
+}
+
+func Logout() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		expiresAt := time.Unix(c.GetInt64("expires_at"), 0)
+		if err := tasks.RevokeToken(c.GetString("jti"), time.Until(expiresAt)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke token"})
+			return
+		}
+
+		if sessionId := c.GetString("session_id"); sessionId != "" {
+			if err := helper.RevokeSession(sessionId); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to end session"})
+				return
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
+	}
+}
+
+func GetMySessions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sessions, err := helper.GetUserSessions(c.GetString("uid"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing sessions"})
+			return
+		}
+
+		currentSessionId := c.GetString("session_id")
+		allSessions := []gin.H{}
+		for _, session := range sessions {
+			allSessions = append(allSessions, gin.H{
+				"session_id":   session.Session_id,
+				"user_agent":   session.User_agent,
+				"ip_address":   session.Ip_address,
+				"created_at":   session.Created_at,
+				"last_seen_at": session.Last_seen_at,
+				"expires_at":   session.Expires_at,
+				"current":      session.Session_id == currentSessionId,
+			})
+		}
+
+		c.JSON(http.StatusOK, allSessions)
+	}
+}
+
+func DeleteMySession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sessionId := c.Param("session_id")
+
+		found, err := helper.UserHasSession(c.GetString("uid"), sessionId)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while finding session"})
+			return
+		}
+
+		if !found {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+			return
+		}
+
+		if err := helper.RevokeSession(sessionId); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to end session"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Session ended successfully"})
+	}
+}
+
+func RevokeAllSessions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		userId := c.Param("user_id")
+
+		role := c.GetString("role")
+		if userId != c.GetString("uid") && role != models.ROLE_ADMIN && role != models.ROLE_MANAGER {
+			c.JSON(http.StatusForbidden, gin.H{"error": "you are not allowed to perform this action"})
+			return
+		}
+
+		count, err := userCollection.CountDocuments(ctx, bson.M{"user_id": userId})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while finding user"})
+			return
+		}
+		if count == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+
+		if _, err := helper.RevokeAllUserTokens(userId); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke sessions"})
+			return
+		}
+
+		helper.RecordAudit(c.GetString("uid"), models.AUDIT_SESSIONS_REVOKED, "user", userId, c.ClientIP(), nil)
+
+		c.JSON(http.StatusOK, gin.H{"message": "All sessions revoked successfully"})
+	}
+}
+
+// func Login() gin.HandlerFunc {
+// 	return func(c *gin.Context) {
+
+// 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+// 		var user models.User
+// 		var foundUser models.User
+
+// 		//convert the login data from postman which is in JSON to golang readable format
+
+// 		if err := c.BindJSON(&user); err != nil {
+// 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+// 			return
+// 		}
+
+// 		//find a user with that email and see if that user even exists
+
+// 		err := userCollection.FindOne(ctx, bson.M{"email": user.Email}).Decode(&foundUser)
+// 		defer cancel()
+// 		if err != nil {
+// 			c.JSON(http.StatusInternalServerError, gin.H{"error": "user not found, login seems to be incorrect"})
+// 			return
+// 		}
+
+// 		//then you will verify the password
+
+// 		passwordIsValid, msg := VerifyPassword(*user.Password, *foundUser.Password)
+// 		defer cancel()
+// 		if passwordIsValid != true {
+// 			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+// 			return
+// 		}
+
+// 		//if all goes well, then you'll generate tokens
+
+// 		token, refreshToken, _ := helper.GenerateAllTokens(*foundUser.Email, *foundUser.First_name, *foundUser.Last_name, foundUser.User_id)
+
+// 		//update tokens - token and refersh token
+// 		helper.UpdateAllTokens(token, refreshToken, foundUser.User_id)
+
+// 		//return statusOK
+// 		c.JSON(http.StatusOK, foundUser)
+// 	}
+// }
+
+// userRole returns the role stored on the user, treating accounts created
+// before roles existed as waiters.
+func userRole(user models.User) string {
+	if user.Role == nil || *user.Role == "" {
+		return models.ROLE_WAITER
+	}
+	return *user.Role
+}
+
+// isBootstrapAdmin reports whether the given email is the configured
+// ADMIN_EMAIL and no admin account exists yet. This is how the very first
+// admin gets their role; after that roles are only assigned by admins.
+func isBootstrapAdmin(ctx context.Context, email string) bool {
+	adminEmail := os.Getenv("ADMIN_EMAIL")
+	if adminEmail == "" || !strings.EqualFold(adminEmail, email) {
+		return false
+	}
+
+	count, err := userCollection.CountDocuments(ctx, bson.M{"role": models.ROLE_ADMIN})
+	if err != nil {
+		log.Printf("Error counting admin users: %v", err)
+		return false
+	}
+	return count == 0
+}
+
+// AssignRole changes the role of a user and logs them out everywhere, so
+// they log in again with the new role.
+func AssignRole() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		userId := c.Param("user_id")
+
+		var roleData struct {
+			Role string `json:"role" validate:"required,eq=ADMIN|eq=MANAGER|eq=WAITER|eq=KITCHEN|eq=CASHIER"`
+		}
+
+		if err := c.BindJSON(&roleData); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if validationErr := validate.Struct(roleData); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		var foundUser models.User
+		err := userCollection.FindOne(ctx, bson.M{"user_id": userId}).Decode(&foundUser)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			} else {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while finding user"})
+			}
+			return
+		}
+
+		// Never leave the system without an admin
+		if roleData.Role != models.ROLE_ADMIN && isLastAdmin(ctx, c, foundUser) {
+			return
+		}
+
+		update := bson.M{
+			"$set": bson.M{
+				"role":       roleData.Role,
+				"updated_at": time.Now(),
+			},
+		}
+
+		_, err = userCollection.UpdateOne(ctx, bson.M{"user_id": userId}, update)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user role"})
+			return
+		}
+
+		// The role travels inside the tokens, they would keep the old one.
+		if !endAllSessions(c, &foundUser) {
+			return
+		}
+
+		helper.RecordAudit(c.GetString("uid"), models.AUDIT_ROLE_ASSIGNED, "user", userId, c.ClientIP(), map[string]interface{}{"from": userRole(foundUser), "to": roleData.Role})
+
+		c.JSON(http.StatusOK, gin.H{"message": "Role updated successfully", "user_id": userId, "role": roleData.Role})
+	}
+}
+
+// SetPin sets or changes the PIN the user logs in with on shared terminals.
+// The current password is required so a PIN cannot be set from an unlocked
+// session alone.
+func SetPin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var pinData struct {
+			Password string `json:"password" binding:"required"`
+			Pin      string `json:"pin" binding:"required,numeric,min=4,max=6"`
+		}
+
+		if err := c.BindJSON(&pinData); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		foundUser, ok := verifyCurrentPassword(ctx, c, pinData.Password)
+		if !ok {
+			return
+		}
+
+		pin := HashPassword(pinData.Pin)
+		_, err := userCollection.UpdateOne(
+			ctx,
+			bson.M{"user_id": foundUser.User_id},
+			bson.M{"$set": bson.M{"pin": pin, "updated_at": time.Now()}},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set the PIN"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "PIN set successfully"})
+	}
+}
+
+func GetMe() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		userView, err := findUserView(ctx, c.GetString("uid"))
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching the user"})
+			return
+		}
+
+		c.JSON(http.StatusOK, userView)
+	}
+}
+
+// UpdateMe changes the name, phone or avatar of the user. Email, password and
+// role have their own endpoints.
+func UpdateMe() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var profileData struct {
+			First_name *string `json:"first_name" validate:"omitempty,min=2,max=100"`
+			Last_name  *string `json:"last_name" validate:"omitempty,min=2,max=100"`
+			Phone      *string `json:"phone" validate:"omitempty,min=1"`
+			Avatar     *string `json:"avatar" validate:"omitempty,url"`
+		}
+
+		if err := c.BindJSON(&profileData); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		validationErr := validate.Struct(profileData)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		var updateObj primitive.D
+
+		if profileData.First_name != nil {
+			updateObj = append(updateObj, bson.E{Key: "first_name", Value: profileData.First_name})
+		}
+
+		if profileData.Last_name != nil {
+			updateObj = append(updateObj, bson.E{Key: "last_name", Value: profileData.Last_name})
+		}
+
+		if profileData.Phone != nil {
+			updateObj = append(updateObj, bson.E{Key: "phone", Value: profileData.Phone})
+		}
+
+		if profileData.Avatar != nil {
+			updateObj = append(updateObj, bson.E{Key: "avatar", Value: profileData.Avatar})
+		}
+
+		updatedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		updateObj = append(updateObj, bson.E{Key: "updated_at", Value: updatedAt})
+
+		var updatedUser UserViewFormat
+		opts := options.FindOneAndUpdate().
+			SetReturnDocument(options.After).
+			SetProjection(userViewProjection)
+		err := userCollection.FindOneAndUpdate(
+			ctx,
+			bson.M{"user_id": c.GetString("uid")},
+			bson.D{
+				{Key: "$set", Value: updateObj},
+			},
+			opts,
+		).Decode(&updatedUser)
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "profile update failed"})
+			return
+		}
+
+		updatedUser.withDefaultRole()
+		c.JSON(http.StatusOK, updatedUser)
+	}
+}
+
+// ChangePassword sets a new password for the logged in user. All existing
+// tokens and sessions die with the old password, the caller gets a fresh
+// session in the response.
+func ChangePassword() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var passwordData struct {
+			Current_password string `json:"current_password" binding:"required"`
+			New_password     string `json:"new_password" binding:"required,min=6"`
+		}
+
+		if err := c.BindJSON(&passwordData); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		foundUser, ok := verifyCurrentPassword(ctx, c, passwordData.Current_password)
+		if !ok {
+			return
+		}
+
+		password := HashPassword(passwordData.New_password)
+		updatedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		_, err := userCollection.UpdateOne(
+			ctx,
+			bson.M{"user_id": foundUser.User_id},
+			bson.M{"$set": bson.M{"password": password, "updated_at": updatedAt}},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update password"})
+			return
+		}
+
+		if !endAllSessions(c, &foundUser) {
+			return
+		}
+
+		helper.RecordAudit(foundUser.User_id, models.AUDIT_PASSWORD_CHANGED, "user", foundUser.User_id, c.ClientIP(), nil)
+
+		respondWithNewSession(c, foundUser)
+	}
+}
+
+// RequestEmailChange sends an OTP to the new address. The email only changes
+// once ConfirmEmailChange gets that OTP back.
+func RequestEmailChange() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var emailData struct {
+			Password  string `json:"password" binding:"required"`
+			New_email string `json:"new_email" binding:"required,email"`
+		}
+
+		if err := c.BindJSON(&emailData); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		foundUser, ok := verifyCurrentPassword(ctx, c, emailData.Password)
+		if !ok {
+			return
+		}
+
+		if !isEmailAvailable(ctx, c, emailData.New_email) {
+			return
+		}
+
+		subject := emailChangeSubject(foundUser.User_id, emailData.New_email)
+		if isOTPCoolingDown(c, tasks.OTP_PURPOSE_EMAIL_CHANGE, subject) {
+			return
+		}
+
+		otp, err := tasks.GenerateOTP()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate OTP"})
+			return
+		}
+
+		err = tasks.StoreOTP(tasks.OTP_PURPOSE_EMAIL_CHANGE, subject, otp)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store OTP"})
+			return
+		}
+
+		err = tasks.QueueEmailChangeEmail(emailData.New_email, otp)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue email change email"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "An OTP has been sent to your new email"})
+	}
+}
+
+// ConfirmEmailChange swaps the email once the OTP sent to the new address is
+// confirmed. Like a password change it ends all other sessions.
+func ConfirmEmailChange() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var confirmData struct {
+			New_email string `json:"new_email" binding:"required,email"`
+			OTP       string `json:"otp" binding:"required"`
+		}
+
+		if err := c.BindJSON(&confirmData); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		userId := c.GetString("uid")
+		subject := emailChangeSubject(userId, confirmData.New_email)
+
+		if isThrottled(c, "otp", subject) {
+			return
+		}
+
+		valid, err := tasks.VerifyOTP(tasks.OTP_PURPOSE_EMAIL_CHANGE, subject, confirmData.OTP)
+		if err != nil {
+			if err == tasks.ErrOTPNotFound {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired OTP"})
+			} else {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve OTP"})
+			}
+			return
+		}
+
+		if !valid {
+			registerWrongOTP(c, "otp", tasks.OTP_PURPOSE_EMAIL_CHANGE, subject)
+			return
+		}
+
+		resetAttempts("otp", subject)
+
+		// The address may have been taken while the OTP was on its way
+		if !isEmailAvailable(ctx, c, confirmData.New_email) {
+			return
+		}
+
+		var foundUser models.User
+		updatedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		err = userCollection.FindOneAndUpdate(
+			ctx,
+			bson.M{"user_id": userId},
+			bson.M{"$set": bson.M{"email": confirmData.New_email, "updated_at": updatedAt}},
+			opts,
+		).Decode(&foundUser)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update email"})
+			return
+		}
+
+		if !endAllSessions(c, &foundUser) {
+			return
+		}
+
+		helper.RecordAudit(foundUser.User_id, models.AUDIT_EMAIL_CHANGED, "user", foundUser.User_id, c.ClientIP(), nil)
+
+		respondWithNewSession(c, foundUser)
+	}
+}
+
+// verifyCurrentPassword loads the logged in user and checks their password,
+// counting wrong guesses like failed logins.
+func verifyCurrentPassword(ctx context.Context, c *gin.Context, password string) (models.User, bool) {
+	var foundUser models.User
+	err := userCollection.FindOne(ctx, bson.M{"user_id": c.GetString("uid")}).Decode(&foundUser)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return foundUser, false
+	}
+
+	if isThrottled(c, "login", *foundUser.Email) {
+		return foundUser, false
+	}
+
+	passwordIsValid, msg := VerifyPassword(password, *foundUser.Password)
+	if !passwordIsValid {
+		if registerFailedAttempt(c, "login", *foundUser.Email) {
+			return foundUser, false
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": msg})
+		return foundUser, false
+	}
+
+	resetAttempts("login", *foundUser.Email)
+	return foundUser, true
+}
+
+func isEmailAvailable(ctx context.Context, c *gin.Context, email string) bool {
+	count, err := userCollection.CountDocuments(ctx, bson.M{"email": email})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while checking for the email"})
+		return false
+	}
+
+	if count > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "this email already exists"})
+		return false
+	}
+	return true
+}
+
+// emailChangeSubject ties an email change OTP to both the user and the new
+// address, so it cannot be used to claim any other address.
+func emailChangeSubject(userId string, newEmail string) string {
+	return userId + ":" + newEmail
+}
+
+// endAllSessions kills every token the user holds and removes their
+// sessions. The user is updated with the new token version so fresh tokens
+// can be issued.
+func endAllSessions(c *gin.Context, user *models.User) bool {
+	tokenVersion, err := helper.RevokeAllUserTokens(user.User_id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke existing tokens"})
+		return false
+	}
+	user.Token_version = tokenVersion
+	return true
+}
+
+// DeactivateUser blocks the account of someone who left. Their tokens stop
+// working right away and they can no longer log in, but nothing is deleted.
+func DeactivateUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		foundUser, ok := findUserByParam(ctx, c)
+		if !ok {
+			return
+		}
+
+		if foundUser.Is_deactivated {
+			c.JSON(http.StatusConflict, gin.H{"error": "user is already deactivated"})
+			return
+		}
+		if isLastAdmin(ctx, c, foundUser) {
+			return
+		}
+
+		now := time.Now()
+		_, err := userCollection.UpdateOne(
+			ctx,
+			bson.M{"user_id": foundUser.User_id},
+			bson.M{"$set": bson.M{"is_deactivated": true, "deactivated_at": now, "updated_at": now}},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to deactivate user"})
+			return
+		}
+
+		if !endAllSessions(c, &foundUser) {
+			return
+		}
+
+		helper.RecordAudit(c.GetString("uid"), models.AUDIT_USER_DEACTIVATED, "user", foundUser.User_id, c.ClientIP(), nil)
+
+		c.JSON(http.StatusOK, gin.H{"message": "User deactivated successfully", "user_id": foundUser.User_id})
+	}
+}
+
+func ReactivateUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		foundUser, ok := findUserByParam(ctx, c)
+		if !ok {
+			return
+		}
+
+		if foundUser.Erased_at != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "an erased user cannot be reactivated"})
+			return
+		}
+		if !foundUser.Is_deactivated {
+			c.JSON(http.StatusConflict, gin.H{"error": "user is not deactivated"})
+			return
+		}
+
+		_, err := userCollection.UpdateOne(
+			ctx,
+			bson.M{"user_id": foundUser.User_id},
+			bson.M{
+				"$set":   bson.M{"is_deactivated": false, "updated_at": time.Now()},
+				"$unset": bson.M{"deactivated_at": ""},
+			},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reactivate user"})
+			return
+		}
+
+		helper.RecordAudit(c.GetString("uid"), models.AUDIT_USER_REACTIVATED, "user", foundUser.User_id, c.ClientIP(), nil)
+
+		c.JSON(http.StatusOK, gin.H{"message": "User reactivated successfully", "user_id": foundUser.User_id})
+	}
+}
+
+// ExportUserData returns everything we hold about the user as a JSON archive:
+// their profile, sessions, the orders they created and their audit trail.
+// Users can export their own data, admins anyone's.
+func ExportUserData() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		userId := c.Param("user_id")
+		if userId != c.GetString("uid") && c.GetString("role") != models.ROLE_ADMIN {
+			c.JSON(http.StatusForbidden, gin.H{"error": "you are not allowed to perform this action"})
+			return
+		}
+
+		foundUser, ok := findUserByParam(ctx, c)
+		if !ok {
+			return
+		}
+
+		sessions, err := helper.GetUserSessions(userId)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while exporting sessions"})
+			return
+		}
+
+		result, err := orderCollection.Find(ctx, bson.M{"created_by": userId})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while exporting orders"})
+			return
+		}
+		orders := []bson.M{}
+		if err = result.All(ctx, &orders); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while exporting orders"})
+			return
+		}
+
+		auditEntries, err := helper.GetUserAuditEntries(userId)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while exporting audit entries"})
+			return
+		}
+
+		helper.RecordAudit(c.GetString("uid"), models.AUDIT_USER_EXPORTED, "user", userId, c.ClientIP(), nil)
+
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"user-%s-export.json\"", userId))
+		c.JSON(http.StatusOK, gin.H{
+			"exported_at":   time.Now(),
+			"profile":       newUserView(foundUser),
+			"sessions":      sessions,
+			"orders":        orders,
+			"audit_entries": auditEntries,
+		})
+	}
+}
+
+// EraseUser anonymises the personal data of a user for a right to erasure
+// request. The user document stays so that orders and the audit trail keep
+// pointing at it, the audit trail loses the IP addresses of the user's own
+// actions, and invoices are financial records that are never touched.
+func EraseUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		foundUser, ok := findUserByParam(ctx, c)
+		if !ok {
+			return
+		}
+
+		if foundUser.Erased_at != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "user is already erased"})
+			return
+		}
+		if isLastAdmin(ctx, c, foundUser) {
+			return
+		}
+
+		now := time.Now()
+		erasedEmail := "erased-" + foundUser.User_id + "@erased.invalid"
+		update := bson.M{
+			"$set": bson.M{
+				"first_name":     "Erased",
+				"last_name":      "User",
+				"email":          erasedEmail,
+				"phone":          "",
+				"password":       "",
+				"totp_enabled":   false,
+				"is_deactivated": true,
+				"deactivated_at": now,
+				"erased_at":      now,
+				"updated_at":     now,
+			},
+			"$unset": bson.M{
+				"avatar":         "",
+				"pin":            "",
+				"totp_secret":    "",
+				"recovery_codes": "",
+				// Stored by logins from before sessions existed.
+				"token":         "",
+				"refresh_token": "",
+			},
+		}
+
+		_, err := userCollection.UpdateOne(ctx, bson.M{"user_id": foundUser.User_id}, update)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to erase user"})
+			return
+		}
+
+		_, err = invitationCollection.UpdateMany(
+			ctx,
+			bson.M{"email": foundUser.Email},
+			bson.M{"$set": bson.M{"email": erasedEmail, "first_name": nil, "last_name": nil}},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to erase invitations"})
+			return
+		}
+
+		if err := helper.EraseAuditPersonalData(foundUser.User_id); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to erase the audit trail"})
+			return
+		}
+
+		if !endAllSessions(c, &foundUser) {
+			return
+		}
+
+		ipAddress := c.ClientIP()
+		if c.GetString("uid") == foundUser.User_id {
+			ipAddress = ""
+		}
+		helper.RecordAudit(c.GetString("uid"), models.AUDIT_USER_ERASED, "user", foundUser.User_id, ipAddress, nil)
+
+		c.JSON(http.StatusOK, gin.H{"message": "User erased successfully", "user_id": foundUser.User_id})
+	}
+}
+
+func findUserByParam(ctx context.Context, c *gin.Context) (models.User, bool) {
+	var foundUser models.User
+	err := userCollection.FindOne(ctx, bson.M{"user_id": c.Param("user_id")}).Decode(&foundUser)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while finding user"})
+		}
+		return foundUser, false
+	}
+	return foundUser, true
+}
+
+// isLastAdmin responds with 409 and reports true when the user is the only
+// active admin left, whose role or account must not go away.
+func isLastAdmin(ctx context.Context, c *gin.Context, user models.User) bool {
+	if userRole(user) != models.ROLE_ADMIN || user.Is_deactivated {
+		return false
+	}
+
+	count, err := userCollection.CountDocuments(ctx, bson.M{"role": models.ROLE_ADMIN, "is_deactivated": bson.M{"$ne": true}})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while counting admin users"})
+		return true
+	}
+	if count <= 1 {
+		c.JSON(http.StatusConflict, gin.H{"error": "this is the last admin, promote another admin first"})
+		return true
+	}
+	return false
+}
+
+func HashPassword(password string) string {
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return string(bytes)
+}
+
+func VerifyPassword(userPassword string, providedPassword string) (bool, string) {
+
+	err := bcrypt.CompareHashAndPassword([]byte(providedPassword), []byte(userPassword))
+	check := true
+	msg := ""
+
+	if err != nil {
+		msg = fmt.Sprintf("login or password is incorrect")
+		check = false
+	}
+	return check, msg
+}
+
+func VerifyOTP() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var verificationData struct {
+			Email string `json:"email" binding:"required,email"`
+			OTP   string `json:"otp" binding:"required"`
+		}
+
+		if err := c.BindJSON(&verificationData); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if isThrottled(c, "otp", verificationData.Email) {
+			return
+		}
+
+		// Check and consume the OTP
+		valid, err := tasks.VerifyOTP(tasks.OTP_PURPOSE_VERIFY, verificationData.Email, verificationData.OTP)
+		if err != nil {
+			if err == tasks.ErrOTPNotFound {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired OTP"})
+			} else {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve OTP"})
+			}
+			return
+		}
+
+		if !valid {
+			registerWrongOTP(c, "otp", tasks.OTP_PURPOSE_VERIFY, verificationData.Email)
+			return
+		}
+
+		resetAttempts("otp", verificationData.Email)
+
+		// Update user verification status
+		update := bson.M{
+			"$set": bson.M{
+				"is_verified":  true,
+				"is_otp_valid": false,
+			},
+		}
+
+		_, err = userCollection.UpdateOne(
+			ctx,
+			bson.M{"email": verificationData.Email},
+			update,
+		)
+
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user verification status"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "OTP verified successfully"})
+	}
+}
+
+func ForgotPassword() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		var user models.User
+
+		if err := c.BindJSON(&user); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if user.Email == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "email is required"})
+			return
+		}
+
+		// Check if user exists
+		var foundUser models.User
+		err := userCollection.FindOne(ctx, bson.M{"email": user.Email}).Decode(&foundUser)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+
+		if isOTPCoolingDown(c, tasks.OTP_PURPOSE_RESET, *user.Email) {
+			return
+		}
+
+		// Generate OTP
+		otp, err := tasks.GenerateOTP()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate OTP"})
+			return
+		}
+
+		// Store OTP
+		err = tasks.StoreOTP(tasks.OTP_PURPOSE_RESET, *user.Email, otp)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store OTP"})
+			return
+		}
+
+		// Queue reset password email
+		err = tasks.QueueResetPasswordEmail(*user.Email, otp)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue reset password email"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Reset password OTP sent to your email"})
+	}
+}
+
+func ResetPassword() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+		var resetData struct {
+			Email       string `json:"email" binding:"required,email"`
+			OTP         string `json:"otp" binding:"required"`
+			NewPassword string `json:"new_password" binding:"required,min=6"`
+		}
+
+		if err := c.BindJSON(&resetData); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if isThrottled(c, "reset", resetData.Email) {
+			return
+		}
+
+		// Verify and consume the OTP
+		valid, err := tasks.VerifyOTP(tasks.OTP_PURPOSE_RESET, resetData.Email, resetData.OTP)
+		if err != nil {
+			if err == tasks.ErrOTPNotFound {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired OTP"})
+			} else {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve OTP"})
+			}
+			return
+		}
+
+		if !valid {
+			registerWrongOTP(c, "reset", tasks.OTP_PURPOSE_RESET, resetData.Email)
+			return
+		}
+
+		resetAttempts("reset", resetData.Email)
+
+		// Hash new password
+		hashedPassword := HashPassword(resetData.NewPassword)
+
+		// Update password in database
+		update := bson.M{
+			"$set": bson.M{
+				"password": hashedPassword,
+			},
+		}
+
+		var foundUser models.User
+		err = userCollection.FindOneAndUpdate(
+			ctx,
+			bson.M{"email": resetData.Email},
+			update,
+		).Decode(&foundUser)
+
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update password"})
+			return
+		}
+
+		if !endAllSessions(c, &foundUser) {
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Password reset successfully"})
+	}
+}
+
+func ResendOTP() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		var resendData struct {
+			Email   string `json:"email" binding:"required,email"`
+			Purpose string `json:"purpose" binding:"required,oneof=verify reset"`
+		}
+
+		if err := c.BindJSON(&resendData); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var foundUser models.User
+		err := userCollection.FindOne(ctx, bson.M{"email": resendData.Email}).Decode(&foundUser)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+
+		if resendData.Purpose == tasks.OTP_PURPOSE_VERIFY && foundUser.Is_Verified {
+			c.JSON(http.StatusConflict, gin.H{"error": "user is already verified"})
+			return
+		}
+
+		if isOTPCoolingDown(c, resendData.Purpose, resendData.Email) {
+			return
+		}
+
+		otp, err := tasks.GenerateOTP()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate OTP"})
+			return
+		}
+
+		err = tasks.StoreOTP(resendData.Purpose, resendData.Email, otp)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store OTP"})
+			return
+		}
+
+		if resendData.Purpose == tasks.OTP_PURPOSE_RESET {
+			err = tasks.QueueResetPasswordEmail(resendData.Email, otp)
+		} else {
+			err = tasks.QueueVerificationEmail(resendData.Email, otp)
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue OTP email"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "A new OTP has been sent to your email"})
+	}
+}
+
+// isOTPCoolingDown responds with 429 and reports true when an OTP for the
+// purpose was sent to the email too recently.
+func isOTPCoolingDown(c *gin.Context, purpose string, email string) bool {
+	retryAfter, err := tasks.StartOTPCooldown(purpose, email)
+	if err != nil {
+		log.Printf("Error starting OTP cooldown: %v", err)
+		return false
+	}
+
+	if retryAfter > 0 {
+		seconds := int(math.Ceil(retryAfter.Seconds()))
+		c.Header("Retry-After", strconv.Itoa(seconds))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "an OTP was sent recently, please wait before requesting another one", "retry_after": seconds})
+		return true
+	}
+	return false
+}
+
+// attemptKeys returns the per-email and per-IP counters for a throttled action.
+func attemptKeys(action string, email string, ip string) (emailKey string, ipKey string) {
+	return action + ":email:" + strings.ToLower(email), action + ":ip:" + ip
+}
+
+// isThrottled responds with 429 and reports true when either the email or the
+// client IP is locked out of the action.
+func isThrottled(c *gin.Context, action string, email string) bool {
+	emailKey, ipKey := attemptKeys(action, email, c.ClientIP())
+
+	var retryAfter time.Duration
+	for _, key := range []string{emailKey, ipKey} {
+		lockout, err := tasks.CheckLockout(key)
+		if err != nil {
+			log.Printf("Error checking lockout: %v", err)
+			continue
+		}
+		if lockout > retryAfter {
+			retryAfter = lockout
+		}
+	}
+
+	if retryAfter > 0 {
+		respondTooManyAttempts(c, retryAfter)
+		return true
+	}
+	return false
+}
+
+// registerFailedAttempt counts a failure against the email and the client IP.
+// If that locks either of them out it responds with 429 and reports true.
+func registerFailedAttempt(c *gin.Context, action string, email string) bool {
+	emailKey, ipKey := attemptKeys(action, email, c.ClientIP())
+
+	emailLockout, err := tasks.RegisterFailedAttempt(emailKey, tasks.EMAIL_ATTEMPT_LIMIT)
+	if err != nil {
+		log.Printf("Error registering failed attempt: %v", err)
+	}
+
+	ipLockout, err := tasks.RegisterFailedAttempt(ipKey, tasks.IP_ATTEMPT_LIMIT)
+	if err != nil {
+		log.Printf("Error registering failed attempt: %v", err)
+	}
+
+	retryAfter := emailLockout
+	if ipLockout > retryAfter {
+		retryAfter = ipLockout
+	}
+
+	if retryAfter > 0 {
+		respondTooManyAttempts(c, retryAfter)
+		return true
+	}
+	return false
+}
+
+// registerWrongOTP handles a wrong OTP guess: it counts the failure, throws
+// the OTP away after too many guesses and writes the matching response.
+func registerWrongOTP(c *gin.Context, action string, purpose string, email string) {
+	if registerFailedAttempt(c, action, email) {
+		return
+	}
+
+	invalidated, err := tasks.RegisterWrongOTP(purpose, email)
+	if err != nil {
+		log.Printf("Error registering wrong OTP: %v", err)
+	}
+
+	if invalidated {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Too many invalid attempts, please request a new OTP"})
+		return
+	}
+	c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid OTP"})
+}
+
+func resetAttempts(action string, email string) {
+	emailKey, _ := attemptKeys(action, email, "")
+	if err := tasks.ResetAttempts(emailKey); err != nil {
+		log.Printf("Error resetting attempts: %v", err)
+	}
+}
+
+func respondTooManyAttempts(c *gin.Context, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many attempts, please try again later", "retry_after": seconds})
+}
@@ -0,0 +1,332 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"golang-restaurant-management/database"
+	"golang-restaurant-management/models"
+	"golang-restaurant-management/tasks"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// voidRequest is the body of the void and cancel endpoints. Staff below
+// manager who void something the kitchen already started on have a manager
+// approve it on the spot with their user id and PIN.
+type voidRequest struct {
+	Type        string  `json:"type" validate:"omitempty,eq=VOID|eq=COMP"`
+	Reason_code string  `json:"reason_code" validate:"required"`
+	Reason      *string `json:"reason" validate:"omitempty,max=500"`
+	Manager_id  *string `json:"manager_id"`
+	Manager_pin *string `json:"manager_pin"`
+}
+
+// VoidOrderItem voids or comps a single item of an open, unpaid order. Voided items
+// are dropped from the bill and the kitchen, comped items are still made but
+// cost nothing.
+func VoidOrderItem() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		request, ok := bindVoidRequest(c)
+		if !ok {
+			return
+		}
+		if request.Type == "" {
+			request.Type = models.VOID_TYPE_VOID
+		}
+
+		var orderItem models.OrderItem
+		err := orderItemCollection.FindOne(ctx, bson.M{"order_item_id": c.Param("order_item_id")}).Decode(&orderItem)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Order item not found"})
+			} else {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while fetching the order item"})
+			}
+			return
+		}
+
+		if orderItem.Void != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "the item is already voided"})
+			return
+		}
+
+		var order models.Order
+		if err := orderCollection.FindOne(ctx, bson.M{"order_id": orderItem.Order_id}).Decode(&order); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while finding order"})
+			return
+		}
+		if status := orderStatus(order); status == models.ORDER_CLOSED || status == models.ORDER_CANCELLED {
+			c.JSON(http.StatusConflict, gin.H{"error": "items of a " + status + " order cannot be voided"})
+			return
+		}
+		// The invoice totals the items every time it is read, voiding one
+		// would change a bill that was already settled.
+		if !checkOrderUnpaid(ctx, c, order.Order_id) {
+			return
+		}
+
+		fired := itemFired(orderItem)
+		approvedBy, ok := approveVoid(ctx, c, request, fired)
+		if !ok {
+			return
+		}
+
+		void := newVoidRecord(c, request, request.Type, approvedBy, fired)
+
+		result, err := orderItemCollection.UpdateOne(
+			ctx,
+			bson.M{"order_item_id": orderItem.Order_item_id, "void": nil},
+			bson.M{"$set": bson.M{"void": void, "updated_at": void.Voided_at}},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Order item update failed"})
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "the item is already voided"})
+			return
+		}
+
+		orderItem.Void = &void
+		orderItem.Updated_at = void.Voided_at
+
+		if void.Type == models.VOID_TYPE_VOID {
+			publishKitchenEvent(tasks.KitchenEvent{
+				Type:       tasks.KITCHEN_ORDER_ITEM_CANCELLED,
+				Order_id:   orderItem.Order_id,
+				Station:    kitchenStationOf(orderItem),
+				Reason:     void.Reason,
+				Order_item: orderItem,
+			})
+			// The voided item may have been the last one the kitchen was
+			// waiting for.
+			advanceOrderIfPrepared(ctx, orderItem.Order_id, c.GetString("uid"))
+		}
+
+		c.JSON(http.StatusOK, orderItem)
+	}
+}
+
+// CancelOrder cancels an order and voids all of its items with the same
+// reason, in one transaction.
+func CancelOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		request, ok := bindVoidRequest(c)
+		if !ok {
+			return
+		}
+		if request.Type != "" && request.Type != models.VOID_TYPE_VOID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "cancelled orders are voided, not comped"})
+			return
+		}
+
+		if !models.CanMoveOrderTo(c.GetString("role"), models.ORDER_CANCELLED) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "you are not allowed to cancel orders"})
+			return
+		}
+
+		var order models.Order
+		err := orderCollection.FindOne(ctx, bson.M{"order_id": c.Param("order_id")}).Decode(&order)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+			} else {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while finding order"})
+			}
+			return
+		}
+
+		if current := orderStatus(order); !models.CanTransition(current, models.ORDER_CANCELLED) {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("an order cannot move from %s to %s", current, models.ORDER_CANCELLED)})
+			return
+		}
+
+		cursor, err := orderItemCollection.Find(ctx, bson.M{"order_id": order.Order_id, "void": nil})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while finding order items"})
+			return
+		}
+		var orderItems []models.OrderItem
+		if err = cursor.All(ctx, &orderItems); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while finding order items"})
+			return
+		}
+
+		fired := false
+		for _, orderItem := range orderItems {
+			fired = fired || itemFired(orderItem)
+		}
+
+		approvedBy, ok := approveVoid(ctx, c, request, fired)
+		if !ok {
+			return
+		}
+
+		cancellation := newVoidRecord(c, request, models.VOID_TYPE_VOID, approvedBy, fired)
+		reason := request.Reason_code
+		if request.Reason != nil {
+			reason += ": " + *request.Reason
+		}
+
+		// The transaction may run the function more than once, so it must
+		// start from the loaded order every time.
+		var cancelled models.Order
+		err = database.RunTransaction(ctx, func(sessionCtx mongo.SessionContext) error {
+			var err error
+			cancelled, err = transitionOrder(sessionCtx, order, models.ORDER_CANCELLED, c.GetString("uid"), &reason)
+			if err != nil {
+				return err
+			}
+
+			_, err = orderCollection.UpdateOne(sessionCtx, bson.M{"order_id": order.Order_id}, bson.M{"$set": bson.M{"cancellation": cancellation}})
+			if err != nil {
+				return err
+			}
+
+			for _, orderItem := range orderItems {
+				void := cancellation
+				void.Fired = itemFired(orderItem)
+				_, err = orderItemCollection.UpdateOne(
+					sessionCtx,
+					bson.M{"order_item_id": orderItem.Order_item_id, "void": nil},
+					bson.M{"$set": bson.M{"void": void, "updated_at": void.Voided_at}},
+				)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err == errOrderChanged {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Order was not cancelled"})
+			return
+		}
+
+		order = cancelled
+		order.Cancellation = &cancellation
+		publishOrderStatus(order)
+
+		c.JSON(http.StatusOK, order)
+	}
+}
+
+func bindVoidRequest(c *gin.Context) (voidRequest, bool) {
+	var request voidRequest
+
+	if err := c.BindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return request, false
+	}
+
+	if validationErr := validate.Struct(request); validationErr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+		return request, false
+	}
+
+	if !models.ValidVoidReasonCode(request.Reason_code) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown reason code", "reason_codes": models.VOID_REASON_CODES})
+		return request, false
+	}
+
+	if request.Reason_code == "OTHER" && (request.Reason == nil || *request.Reason == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "a reason is required for the OTHER reason code"})
+		return request, false
+	}
+
+	return request, true
+}
+
+func newVoidRecord(c *gin.Context, request voidRequest, voidType string, approvedBy *string, fired bool) models.VoidRecord {
+	return models.VoidRecord{
+		Type:        voidType,
+		Reason_code: request.Reason_code,
+		Reason:      request.Reason,
+		Actor_id:    c.GetString("uid"),
+		Approved_by: approvedBy,
+		Fired:       fired,
+		Voided_at:   time.Now(),
+	}
+}
+
+// itemFired reports whether the kitchen already started on an item.
+func itemFired(orderItem models.OrderItem) bool {
+	if orderItem.Billing_only {
+		return false
+	}
+	status := prepStatus(orderItem)
+	return status == models.PREP_COOKING || status == models.PREP_DONE
+}
+
+// approveVoid checks that a void may go ahead and returns the manager who
+// approved it, if any. Managers approve their own voids, everybody else
+// needs a manager's PIN once the kitchen started unless the void policy says
+// otherwise. It answers the request itself when the void is refused.
+func approveVoid(ctx context.Context, c *gin.Context, request voidRequest, fired bool) (*string, bool) {
+	role := c.GetString("role")
+	if role == models.ROLE_ADMIN || role == models.ROLE_MANAGER {
+		uid := c.GetString("uid")
+		return &uid, true
+	}
+
+	if !fired {
+		return nil, true
+	}
+
+	policy, err := getVoidPolicy(ctx)
+	if err != nil {
+		log.Printf("Error reading void policy: %v", err)
+		policy.Require_override = true
+	}
+	if !policy.Require_override {
+		return nil, true
+	}
+
+	if request.Manager_id == nil || request.Manager_pin == nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "the kitchen already started on this, a manager has to approve the void"})
+		return nil, false
+	}
+
+	if isThrottled(c, "pin", *request.Manager_id) {
+		return nil, false
+	}
+
+	var manager models.User
+	err = userCollection.FindOne(ctx, bson.M{"user_id": *request.Manager_id}).Decode(&manager)
+	pinIsValid := false
+	if err == nil && manager.Pin != nil {
+		pinIsValid, _ = VerifyPassword(*request.Manager_pin, *manager.Pin)
+	}
+
+	if !pinIsValid {
+		if registerFailedAttempt(c, "pin", *request.Manager_id) {
+			return nil, false
+		}
+		c.JSON(http.StatusForbidden, gin.H{"error": "manager or PIN is incorrect"})
+		return nil, false
+	}
+
+	resetAttempts("pin", *request.Manager_id)
+
+	managerRole := userRole(manager)
+	if manager.Is_deactivated || (managerRole != models.ROLE_MANAGER && managerRole != models.ROLE_ADMIN) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "only an active manager can approve voids"})
+		return nil, false
+	}
+
+	return &manager.User_id, true
+}
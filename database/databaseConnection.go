@@ -46,3 +46,19 @@ func OpenCollection(client *mongo.Client, collectionName string) *mongo.Collecti
 
 	return collection
 }
+
+// RunTransaction runs fn in a multi-document transaction, retrying it on
+// transient errors. All reads and writes in fn must use the session context
+// it is given. Transactions need MongoDB to run as a replica set.
+func RunTransaction(ctx context.Context, fn func(sessionCtx mongo.SessionContext) error) error {
+	session, err := Client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessionCtx mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sessionCtx)
+	})
+	return err
+}
@@ -0,0 +1,136 @@
+package helper
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"golang-restaurant-management/database"
+	"golang-restaurant-management/models"
+	"golang-restaurant-management/tasks"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var apiKeyCollection *mongo.Collection = database.OpenCollection(database.Client, "api_keys")
+
+// Every key starts with this so that leaked keys are easy to recognise.
+const API_KEY_PREFIX = "rk_"
+
+// How often the last used time of an api key is written back to Mongo.
+const apiKeyTouchInterval = time.Minute
+
+// GenerateAPIKey returns a new plain text api key together with the hash that
+// is stored and the short prefix shown to admins to tell keys apart. The
+// plain text key is only ever handed out once.
+func GenerateAPIKey() (key string, keyHash string, keyPrefix string, err error) {
+	buf := make([]byte, 32)
+	if _, err = rand.Read(buf); err != nil {
+		return "", "", "", err
+	}
+
+	key = API_KEY_PREFIX + hex.EncodeToString(buf)
+	return key, hashToken(key), key[:len(API_KEY_PREFIX)+8], nil
+}
+
+// ValidateAPIKey looks up the key and makes sure it has not expired.
+func ValidateAPIKey(key string) (apiKey models.ApiKey, msg string) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	if !strings.HasPrefix(key, API_KEY_PREFIX) {
+		msg = "the api key is invalid"
+		return
+	}
+
+	err := apiKeyCollection.FindOne(ctx, bson.M{"key_hash": hashToken(key)}).Decode(&apiKey)
+	if err == mongo.ErrNoDocuments {
+		msg = "the api key is invalid"
+		return
+	}
+	if err != nil {
+		log.Printf("Error looking up api key: %v", err)
+		msg = "unable to verify the api key"
+		return
+	}
+
+	if apiKey.Expires_at != nil && apiKey.Expires_at.Before(time.Now()) {
+		msg = "the api key has expired"
+		return
+	}
+
+	return apiKey, msg
+}
+
+// ValidAPIKeyScope reports whether scope is "*" or "<resource>:<read|write>"
+// for a known resource.
+func ValidAPIKeyScope(scope string) bool {
+	if scope == models.API_KEY_SCOPE_ALL {
+		return true
+	}
+
+	parts := strings.Split(scope, ":")
+	if len(parts) != 2 || (parts[1] != "read" && parts[1] != "write") {
+		return false
+	}
+	for _, resource := range models.API_KEY_RESOURCES {
+		if parts[0] == resource {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKeyAllows reports whether the scopes of an api key grant the action on
+// the resource. Api keys only ever reach models.API_KEY_RESOURCES.
+func APIKeyAllows(scopes []string, resource string, action string) bool {
+	for _, apiKeyResource := range models.API_KEY_RESOURCES {
+		if resource == apiKeyResource {
+			return ScopesAllow(scopes, resource, action)
+		}
+	}
+	return false
+}
+
+// ScopesAllow reports whether the scopes grant the action on the resource. A
+// write scope also grants read access.
+func ScopesAllow(scopes []string, resource string, action string) bool {
+	for _, scope := range scopes {
+		if scope == models.API_KEY_SCOPE_ALL || scope == resource+":"+action || scope == resource+":write" {
+			return true
+		}
+	}
+	return false
+}
+
+// TouchAPIKey records that the key was used, at most once per
+// apiKeyTouchInterval.
+func TouchAPIKey(apiKeyId string) {
+	first, err := tasks.RedisClient.SetNX(context.Background(), "api_key_used:"+apiKeyId, 1, apiKeyTouchInterval).Result()
+	if err != nil || !first {
+		return
+	}
+
+	var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err = apiKeyCollection.UpdateOne(ctx, bson.M{"api_key_id": apiKeyId}, bson.M{"$set": bson.M{"last_used_at": time.Now()}})
+	if err != nil {
+		log.Printf("Error updating api key last used time: %v", err)
+	}
+}
+
+// RequestScope returns the resource and action a request needs a scope for,
+// e.g. "orders" and "read" for GET /orders/:order_id.
+func RequestScope(fullPath string, method string) (resource string, action string) {
+	resource = strings.Split(strings.TrimPrefix(fullPath, "/"), "/")[0]
+	action = "write"
+	if method == http.MethodGet || method == http.MethodHead {
+		action = "read"
+	}
+	return resource, action
+}
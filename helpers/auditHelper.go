@@ -0,0 +1,71 @@
+package helper
+
+import (
+	"context"
+	"golang-restaurant-management/database"
+	"golang-restaurant-management/models"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var auditCollection *mongo.Collection = database.OpenCollection(database.Client, "audit")
+
+// RecordAudit writes an entry to the audit trail. Failing to write it is
+// logged but does not fail the action that was audited.
+func RecordAudit(actorId string, action string, targetType string, targetId string, ipAddress string, details map[string]interface{}) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	entry := models.AuditEntry{
+		ID:          primitive.NewObjectID(),
+		Actor_id:    actorId,
+		Action:      action,
+		Target_type: targetType,
+		Target_id:   targetId,
+		Ip_address:  ipAddress,
+		Details:     details,
+		Created_at:  time.Now(),
+	}
+	entry.Audit_id = entry.ID.Hex()
+
+	if _, err := auditCollection.InsertOne(ctx, entry); err != nil {
+		log.Printf("Error recording audit entry %s for %s: %v", action, targetId, err)
+	}
+}
+
+// EraseAuditPersonalData removes the IP addresses from the entries of actions
+// the user took themselves, for erasing the user. The entries stay, as do the
+// addresses of whoever acted upon the user.
+func EraseAuditPersonalData(userId string) error {
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	_, err := auditCollection.UpdateMany(ctx, bson.M{"actor_id": userId}, bson.M{"$set": bson.M{"ip_address": nil}})
+	return err
+}
+
+// GetUserAuditEntries returns the entries where the user acted or was acted
+// upon, newest first.
+func GetUserAuditEntries(userId string) ([]models.AuditEntry, error) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	filter := bson.M{"$or": []bson.M{{"actor_id": userId}, {"target_id": userId}}}
+	opts := options.Find().SetSort(bson.M{"created_at": -1})
+
+	result, err := auditCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []models.AuditEntry{}
+	if err = result.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
@@ -0,0 +1,74 @@
+package helper
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+var ErrOIDCNotConfigured = errors.New("OpenID Connect login is not configured")
+
+var (
+	oidcMu       sync.Mutex
+	oidcProvider *oidc.Provider
+)
+
+// OIDCClaims are the claims of the identity provider mapped to our users.
+type OIDCClaims struct {
+	Subject        string `json:"sub"`
+	Email          string `json:"email"`
+	Email_verified *bool  `json:"email_verified"`
+	Name           string `json:"name"`
+	Given_name     string `json:"given_name"`
+	Family_name    string `json:"family_name"`
+	Phone_number   string `json:"phone_number"`
+	Picture        string `json:"picture"`
+	Nonce          string `json:"nonce"`
+}
+
+// OIDCIssuer returns the configured issuer, empty when OIDC login is off.
+func OIDCIssuer() string {
+	return os.Getenv("OIDC_ISSUER_URL")
+}
+
+// OIDCConfig discovers the identity provider on first use and returns the
+// OAuth2 config and ID token verifier for it. Discovery is retried on the
+// next call if the provider could not be reached.
+func OIDCConfig(ctx context.Context) (*oauth2.Config, *oidc.IDTokenVerifier, error) {
+	issuer := OIDCIssuer()
+	if issuer == "" || os.Getenv("OIDC_CLIENT_ID") == "" {
+		return nil, nil, ErrOIDCNotConfigured
+	}
+
+	oidcMu.Lock()
+	defer oidcMu.Unlock()
+
+	if oidcProvider == nil {
+		provider, err := oidc.NewProvider(ctx, issuer)
+		if err != nil {
+			return nil, nil, err
+		}
+		oidcProvider = provider
+	}
+
+	scopes := []string{oidc.ScopeOpenID, "profile", "email"}
+	if extra := os.Getenv("OIDC_SCOPES"); extra != "" {
+		scopes = append(scopes, strings.Fields(extra)...)
+	}
+
+	config := &oauth2.Config{
+		ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+		Endpoint:     oidcProvider.Endpoint(),
+		Scopes:       scopes,
+	}
+	verifier := oidcProvider.Verifier(&oidc.Config{ClientID: config.ClientID})
+
+	return config, verifier, nil
+}
@@ -0,0 +1,163 @@
+package helper
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"golang-restaurant-management/database"
+	"golang-restaurant-management/models"
+	"golang-restaurant-management/tasks"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var sessionCollection *mongo.Collection = database.OpenCollection(database.Client, "sessions")
+
+// How often the last seen time of a session is written back to Mongo.
+const sessionTouchInterval = time.Minute
+
+func hashToken(signedToken string) string {
+	sum := sha256.Sum256([]byte(signedToken))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateSession stores a new device session. The session id is also the
+// token family carried in every token issued for it.
+func CreateSession(sessionId primitive.ObjectID, userId string, signedRefreshToken string, userAgent string, ipAddress string) error {
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	session := models.Session{
+		ID:                 sessionId,
+		Session_id:         sessionId.Hex(),
+		User_id:            userId,
+		User_agent:         userAgent,
+		Ip_address:         ipAddress,
+		Refresh_token_hash: hashToken(signedRefreshToken),
+		Created_at:         now,
+		Last_seen_at:       now,
+		Expires_at:         now.Add(REFRESH_TOKEN_TTL),
+	}
+
+	_, err := sessionCollection.InsertOne(ctx, session)
+	return err
+}
+
+// RotateSessionToken replaces the refresh token of the session only if it is
+// still previousRefreshToken. It returns false when the session is gone or the
+// token was already rotated, which callers must treat as refresh token reuse.
+func RotateSessionToken(sessionId string, previousRefreshToken string, signedRefreshToken string, ipAddress string) (bool, error) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	filter := bson.M{
+		"session_id":         sessionId,
+		"refresh_token_hash": hashToken(previousRefreshToken),
+		"expires_at":         bson.M{"$gt": now},
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"refresh_token_hash": hashToken(signedRefreshToken),
+			"ip_address":         ipAddress,
+			"last_seen_at":       now,
+			"expires_at":         now.Add(REFRESH_TOKEN_TTL),
+		},
+	}
+
+	result, err := sessionCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount == 1, nil
+}
+
+func SessionExists(sessionId string) (bool, error) {
+	return countSessions(bson.M{"session_id": sessionId})
+}
+
+// UserHasSession reports whether the session belongs to the given user.
+func UserHasSession(userId string, sessionId string) (bool, error) {
+	return countSessions(bson.M{"session_id": sessionId, "user_id": userId})
+}
+
+func countSessions(filter bson.M) (bool, error) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	count, err := sessionCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func GetUserSessions(userId string) ([]models.Session, error) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	filter := bson.M{"user_id": userId, "expires_at": bson.M{"$gt": time.Now()}}
+	opts := options.Find().SetSort(bson.M{"last_seen_at": -1})
+
+	result, err := sessionCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := []models.Session{}
+	if err = result.All(ctx, &sessions); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// RevokeSession ends one device session and invalidates every token that was
+// issued for it.
+func RevokeSession(sessionId string) error {
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	if err := tasks.RevokeTokenFamily(sessionId, REFRESH_TOKEN_TTL); err != nil {
+		return err
+	}
+
+	_, err := sessionCollection.DeleteOne(ctx, bson.M{"session_id": sessionId})
+	return err
+}
+
+// DeleteUserSessions removes every session of the user. Callers are expected
+// to revoke the tokens themselves, see RevokeAllUserTokens.
+func DeleteUserSessions(userId string) error {
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	_, err := sessionCollection.DeleteMany(ctx, bson.M{"user_id": userId})
+	return err
+}
+
+// TouchSession records activity on a session, at most once per
+// sessionTouchInterval so that authenticated requests stay cheap.
+func TouchSession(sessionId string) {
+	if sessionId == "" {
+		return
+	}
+
+	first, err := tasks.RedisClient.SetNX(context.Background(), "session_seen:"+sessionId, 1, sessionTouchInterval).Result()
+	if err != nil || !first {
+		return
+	}
+
+	var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err = sessionCollection.UpdateOne(ctx, bson.M{"session_id": sessionId}, bson.M{"$set": bson.M{"last_seen_at": time.Now()}})
+	if err != nil {
+		log.Printf("Error updating session last seen time: %v", err)
+	}
+}
@@ -2,51 +2,110 @@ package helper
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"golang-restaurant-management/database"
+	"golang-restaurant-management/keystore"
+	"golang-restaurant-management/models"
+	"golang-restaurant-management/tasks"
 	"log"
-	"os"
 	"time"
 
-	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/golang-jwt/jwt/v5"
 	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+const (
+	ACCESS_TOKEN  = "access"
+	REFRESH_TOKEN = "refresh"
+	// Issued after a correct password when the second factor still has to
+	// be checked, or when the user first has to enroll in 2FA.
+	TWO_FACTOR_CHALLENGE_TOKEN  = "2fa_challenge"
+	TWO_FACTOR_ENROLLMENT_TOKEN = "2fa_enrollment"
+	// Issued by a PIN login on a shared terminal, limited to FLOOR_SCOPES.
+	PIN_TOKEN = "pin"
+	// Sent by email to invited staff, see GenerateInvitationToken.
+	INVITATION_TOKEN = "invitation"
+
+	ACCESS_TOKEN_TTL     = 24 * time.Hour
+	REFRESH_TOKEN_TTL    = keystore.MAX_TOKEN_TTL
+	CHALLENGE_TOKEN_TTL  = 5 * time.Minute
+	PIN_TOKEN_TTL        = 2 * time.Hour
+	INVITATION_TOKEN_TTL = 72 * time.Hour
+	// A PIN token stops working when the terminal was idle for this long.
+	PIN_IDLE_TIMEOUT = 5 * time.Minute
+)
+
+// FLOOR_SCOPES is what staff can do after a PIN login: take orders, seat
+// tables and look up the menu and bills. Everything else needs a full login.
+var FLOOR_SCOPES = []string{"orders:write", "orderItems:write", "tables:write", "foods:read", "menus:read", "invoices:read", "terminals:write", "kitchen:read", "kitchen:write", "stations:write", "notes:write"}
+
 type SignedDetails struct {
-	Email      string
-	First_name string
-	Last_name  string
-	Uid        string
-	jwt.StandardClaims
+	Email       string
+	First_name  string
+	Last_name   string
+	Uid         string
+	Role        string
+	Token_type  string
+	Session_id  string
+	Terminal_id string
+	Scopes      []string
+	// Bumped on the user whenever all of their tokens must die, e.g. after
+	// a password change.
+	Token_version int
+	jwt.RegisteredClaims
 }
 
 var userCollection *mongo.Collection = database.OpenCollection(database.Client, "user")
 
-var SECRET_KEY string = os.Getenv("SECRET_KEY")
+func randomID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		log.Panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func registeredClaims(ttl time.Duration) jwt.RegisteredClaims {
+	now := time.Now()
+	return jwt.RegisteredClaims{
+		ID:        randomID(),
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+	}
+}
 
-func GenerateAllTokens(email string, firstName string, lastName string, uid string) (signedToken string, signedRefreshToken string, err error) {
+func GenerateAllTokens(email string, firstName string, lastName string, uid string, role string, sessionId string, tokenVersion int) (signedToken string, signedRefreshToken string, err error) {
 	claims := &SignedDetails{
-		Email:      email,
-		First_name: firstName,
-		Last_name:  lastName,
-		Uid:        uid,
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Local().Add(time.Hour * time.Duration(24)).Unix(),
-		},
+		Email:            email,
+		First_name:       firstName,
+		Last_name:        lastName,
+		Uid:              uid,
+		Role:             role,
+		Token_type:       ACCESS_TOKEN,
+		Session_id:       sessionId,
+		Token_version:    tokenVersion,
+		RegisteredClaims: registeredClaims(ACCESS_TOKEN_TTL),
 	}
 
 	refreshClaims := &SignedDetails{
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Local().Add(time.Hour * time.Duration(168)).Unix(),
-		},
+		Uid:              uid,
+		Token_type:       REFRESH_TOKEN,
+		Session_id:       sessionId,
+		Token_version:    tokenVersion,
+		RegisteredClaims: registeredClaims(REFRESH_TOKEN_TTL),
 	}
 
-	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(SECRET_KEY))
-	refreshToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, refreshClaims).SignedString([]byte(SECRET_KEY))
+	token, err := keystore.Sign(claims)
+	if err != nil {
+		log.Panic(err)
+		return
+	}
 
+	refreshToken, err := keystore.Sign(refreshClaims)
 	if err != nil {
 		log.Panic(err)
 		return
@@ -56,40 +115,136 @@ func GenerateAllTokens(email string, firstName string, lastName string, uid stri
 
 }
 
-func UpdateAllTokens(signedToken string, signedRefreshToken string, userId string) {
+// GenerateChallengeToken issues a short lived token that only proves the
+// password step of a login. It cannot be used as an access token.
+func GenerateChallengeToken(email string, uid string, tokenType string, tokenVersion int) (string, error) {
+	claims := &SignedDetails{
+		Email:            email,
+		Uid:              uid,
+		Token_type:       tokenType,
+		Token_version:    tokenVersion,
+		RegisteredClaims: registeredClaims(CHALLENGE_TOKEN_TTL),
+	}
 
-	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	return keystore.Sign(claims)
+}
+
+// GeneratePinToken issues the token of a PIN login on a terminal. It has no
+// session and no refresh token, staff simply enter their PIN again.
+func GeneratePinToken(email string, firstName string, lastName string, uid string, role string, terminalId string, tokenVersion int) (signedToken string, claims *SignedDetails, err error) {
+	claims = &SignedDetails{
+		Email:            email,
+		First_name:       firstName,
+		Last_name:        lastName,
+		Uid:              uid,
+		Role:             role,
+		Token_type:       PIN_TOKEN,
+		Terminal_id:      terminalId,
+		Scopes:           FLOOR_SCOPES,
+		Token_version:    tokenVersion,
+		RegisteredClaims: registeredClaims(PIN_TOKEN_TTL),
+	}
 
-	var updateObj primitive.D
+	signedToken, err = keystore.Sign(claims)
+	return signedToken, claims, err
+}
 
-	updateObj = append(updateObj, bson.E{"token", signedToken})
-	updateObj = append(updateObj, bson.E{"refresh_token", signedRefreshToken})
+// GenerateInvitationToken issues the token of an invite link. The invitation
+// id is the subject, the token cannot be used for anything but accepting it.
+func GenerateInvitationToken(invitationId string, email string, role string) (signedToken string, claims *SignedDetails, err error) {
+	claims = &SignedDetails{
+		Email:            email,
+		Role:             role,
+		Token_type:       INVITATION_TOKEN,
+		RegisteredClaims: registeredClaims(INVITATION_TOKEN_TTL),
+	}
+	claims.Subject = invitationId
 
-	Updated_at, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-	updateObj = append(updateObj, bson.E{"updated_at", Updated_at})
+	signedToken, err = keystore.Sign(claims)
+	return signedToken, claims, err
+}
 
-	upsert := true
-	filter := bson.M{"user_id": userId}
-	opt := options.UpdateOptions{
-		Upsert: &upsert,
+// RevokeToken puts a single token on the revocation list.
+func RevokeToken(claims *SignedDetails) error {
+	if claims.ID == "" || claims.ExpiresAt == nil {
+		return nil
 	}
+	return tasks.RevokeToken(claims.ID, time.Until(claims.ExpiresAt.Time))
+}
 
-	_, err := userCollection.UpdateOne(
-		ctx,
-		filter,
-		bson.D{
-			{"$set", updateObj},
-		},
-		&opt,
-	)
+// RevokeAllUserTokens invalidates every token issued to the user so far by
+// bumping their token version, and ends all of their sessions. Tokens issued
+// afterwards must carry the returned version.
+func RevokeAllUserTokens(userId string) (int, error) {
+	tokenVersion, err := BumpTokenVersion(userId)
+	if err != nil {
+		return 0, err
+	}
+	return tokenVersion, DeleteUserSessions(userId)
+}
+
+// currentTokenVersion returns the token version of the user, cached in Redis
+// so that validating a token does not hit Mongo on every request.
+func currentTokenVersion(userId string) (int, error) {
+	version, found, err := tasks.CachedTokenVersion(userId)
+	if err != nil || found {
+		return version, err
+	}
+
+	var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
+	var user models.User
+	opts := options.FindOne().SetProjection(bson.M{"token_version": 1})
+	err = userCollection.FindOne(ctx, bson.M{"user_id": userId}, opts).Decode(&user)
 	if err != nil {
-		log.Panic(err)
-		return
+		return 0, err
+	}
+
+	if err := tasks.CacheTokenVersion(userId, user.Token_version); err != nil {
+		log.Printf("Error caching token version: %v", err)
+	}
+	return user.Token_version, nil
+}
+
+// BumpTokenVersion invalidates every token issued to the user so far. Tokens
+// issued afterwards must carry the returned version.
+func BumpTokenVersion(userId string) (int, error) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	var user models.User
+	opts := options.FindOneAndUpdate().
+		SetReturnDocument(options.After).
+		SetProjection(bson.M{"token_version": 1})
+	err := userCollection.FindOneAndUpdate(
+		ctx,
+		bson.M{"user_id": userId},
+		bson.M{"$inc": bson.M{"token_version": 1}},
+		opts,
+	).Decode(&user)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tasks.CacheTokenVersion(userId, user.Token_version); err != nil {
+		return 0, err
+	}
+	return user.Token_version, nil
+}
+
+func isTokenRevoked(claims *SignedDetails) (bool, error) {
+	if claims.ID != "" {
+		revoked, err := tasks.IsTokenRevoked(claims.ID)
+		if err != nil || revoked {
+			return revoked, err
+		}
 	}
-	return
 
+	if claims.Session_id != "" {
+		return tasks.IsTokenFamilyRevoked(claims.Session_id)
+	}
+	return false, nil
 }
 
 func ValidateToken(signedToken string) (claims *SignedDetails, msg string) {
@@ -97,26 +252,48 @@ func ValidateToken(signedToken string) (claims *SignedDetails, msg string) {
 	token, err := jwt.ParseWithClaims(
 		signedToken,
 		&SignedDetails{},
-		func(token *jwt.Token) (interface{}, error) {
-			return []byte(SECRET_KEY), nil
-		},
+		keystore.Keyfunc,
+		jwt.WithValidMethods(keystore.ValidMethods()),
+		jwt.WithExpirationRequired(),
 	)
 
 	//the token is invalid
+	if err != nil {
+		msg = err.Error()
+		return
+	}
 
 	claims, ok := token.Claims.(*SignedDetails)
-	if !ok {
+	if !ok || !token.Valid {
 		msg = fmt.Sprintf("the token is invalid")
-		msg = err.Error()
 		return
 	}
 
-	//the token is expired
-	if claims.ExpiresAt < time.Now().Local().Unix() {
-		msg = fmt.Sprint("token is expired")
-		msg = err.Error()
+	//the token was revoked by a logout or an admin
+	revoked, err := isTokenRevoked(claims)
+	if err != nil {
+		log.Printf("Error checking token revocation: %v", err)
+		msg = "unable to verify the token"
 		return
 	}
+	if revoked {
+		msg = "token has been revoked"
+		return
+	}
+
+	//the password changed or the user was logged out everywhere since
+	if claims.Uid != "" {
+		tokenVersion, err := currentTokenVersion(claims.Uid)
+		if err != nil {
+			log.Printf("Error checking token version: %v", err)
+			msg = "unable to verify the token"
+			return
+		}
+		if claims.Token_version != tokenVersion {
+			msg = "token is no longer valid, please log in again"
+			return
+		}
+	}
 
 	return claims, msg
 
@@ -0,0 +1,195 @@
+// Package keystore holds the keys used to sign and verify JWTs.
+//
+// Keys live as PKCS#8 PEM files named "<kid>.pem" in a key directory. Every
+// key in the directory verifies tokens and is published in the JWKS, the
+// newest one (kids are timestamps, so the lexicographically largest) signs
+// new tokens once it was published for KEY_ACTIVATION_DELAY. Rotating is a
+// matter of adding a newer key and, once the tokens signed with the old one
+// have expired, deleting the old file. See cmd/rotatekeys.
+//
+// Without a key directory tokens are signed with HS256 and SECRET_KEY, which
+// is fine for development but cannot be verified by other services.
+package keystore
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	ALG_RS256 = "RS256"
+	ALG_EDDSA = "EdDSA"
+	ALG_HS256 = "HS256"
+)
+
+type key struct {
+	kid        string
+	alg        string
+	privateKey crypto.Signer
+}
+
+var (
+	mu     sync.RWMutex
+	keyDir string
+	keys   map[string]key
+)
+
+// Load reads every key in dir and replaces the keys loaded before. An empty
+// dir switches to the HS256 fallback.
+func Load(dir string) error {
+	loaded := map[string]key{}
+
+	if dir != "" {
+		paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+		if err != nil {
+			return err
+		}
+		for _, path := range paths {
+			k, err := readKey(path)
+			if err != nil {
+				return fmt.Errorf("loading %s: %w", path, err)
+			}
+			loaded[k.kid] = k
+		}
+		if len(loaded) == 0 {
+			return fmt.Errorf("no keys found in %s, create one with cmd/rotatekeys", dir)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	keyDir = dir
+	keys = loaded
+	return nil
+}
+
+// Reload reads the key directory again every interval so that keys added by
+// a rotation are picked up without a restart. Keys are published as soon as
+// they are read but only sign once every instance had KEY_ACTIVATION_DELAY to
+// read them, so interval has to be well below it. It never returns.
+func Reload(interval time.Duration) {
+	for range time.Tick(interval) {
+		mu.RLock()
+		dir := keyDir
+		mu.RUnlock()
+
+		if dir == "" {
+			continue
+		}
+		if err := Load(dir); err != nil {
+			log.Printf("Error reloading signing keys: %v", err)
+		}
+	}
+}
+
+// Sign signs the claims with the current signing key and sets its kid header.
+func Sign(claims jwt.Claims) (string, error) {
+	mu.RLock()
+	k, ok := keys[signingKidAt(kids(), time.Now())]
+	mu.RUnlock()
+
+	if !ok {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(hmacSecret())
+	}
+
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(k.alg), claims)
+	token.Header["kid"] = k.kid
+	return token.SignedString(k.privateKey)
+}
+
+// Keyfunc finds the verification key of a token for jwt.Parse. Tokens must
+// use the algorithm of the key their kid points to.
+func Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	mu.RLock()
+	defer mu.RUnlock()
+
+	if kid == "" {
+		// Tokens signed before a key directory was configured carry no kid.
+		// They are only accepted while running on the HS256 fallback or when
+		// JWT_ACCEPT_HS256 is set for the transition.
+		if len(keys) > 0 && os.Getenv("JWT_ACCEPT_HS256") != "true" {
+			return nil, errors.New("token has no key id")
+		}
+		if token.Method.Alg() != ALG_HS256 {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		if len(hmacSecret()) == 0 {
+			return nil, errors.New("SECRET_KEY is not set")
+		}
+		return hmacSecret(), nil
+	}
+
+	k, ok := keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	if token.Method.Alg() != k.alg {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return k.privateKey.Public(), nil
+}
+
+// ValidMethods lists the algorithms tokens may be signed with.
+func ValidMethods() []string {
+	return []string{ALG_RS256, ALG_EDDSA, ALG_HS256}
+}
+
+func hmacSecret() []byte {
+	return []byte(os.Getenv("SECRET_KEY"))
+}
+
+func readKey(path string) (key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return key{}, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return key{}, errors.New("no PEM data found")
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return key{}, err
+	}
+
+	k := key{kid: strings.TrimSuffix(filepath.Base(path), ".pem")}
+	switch privateKey := parsed.(type) {
+	case *rsa.PrivateKey:
+		k.alg = ALG_RS256
+		k.privateKey = privateKey
+	case ed25519.PrivateKey:
+		k.alg = ALG_EDDSA
+		k.privateKey = privateKey
+	default:
+		return key{}, fmt.Errorf("unsupported key type %T", parsed)
+	}
+	return k, nil
+}
+
+// kids returns the ids of all loaded keys, oldest first.
+func kids() []string {
+	ids := make([]string, 0, len(keys))
+	for kid := range keys {
+		ids = append(ids, kid)
+	}
+	sort.Strings(ids)
+	return ids
+}
@@ -0,0 +1,54 @@
+package keystore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestSignWaitsForNewKey(t *testing.T) {
+	dir := t.TempDir()
+
+	previous, err := GenerateKey(dir, ALG_RS256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backdated := time.Now().Add(-7*24*time.Hour).UTC().Format(kidTimeFormat) + "-rs256"
+	if err := os.Rename(filepath.Join(dir, previous+".pem"), filepath.Join(dir, backdated+".pem")); err != nil {
+		t.Fatal(err)
+	}
+
+	fresh, err := GenerateKey(dir, ALG_EDDSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Load(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { Load("") })
+
+	published := map[string]bool{}
+	for _, jwk := range PublicKeys().Keys {
+		published[jwk.Kid] = true
+	}
+	if !published[backdated] || !published[fresh] {
+		t.Fatalf("PublicKeys() = %v, want both keys", published)
+	}
+
+	signed, err := Sign(jwt.RegisteredClaims{Subject: "user", ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := jwt.Parse(signed, Keyfunc, jwt.WithValidMethods(ValidMethods()))
+	if err != nil {
+		t.Fatalf("signed token does not verify: %v", err)
+	}
+	if kid := token.Header["kid"]; kid != backdated {
+		t.Errorf("token signed with %v, want the published key %s", kid, backdated)
+	}
+}
@@ -0,0 +1,141 @@
+package keystore
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const kidTimeFormat = "20060102T150405Z"
+
+const (
+	// KEY_ACTIVATION_DELAY is how long a new key is only published before it
+	// signs tokens, so that every instance, and every verifier caching the
+	// JWKS, knows it by then. Instances reload their keys every minute.
+	KEY_ACTIVATION_DELAY = 10 * time.Minute
+	// MAX_TOKEN_TTL is the lifetime of the longest lived tokens, refresh
+	// tokens. A key is kept at least this long after it stopped signing.
+	MAX_TOKEN_TTL = 168 * time.Hour
+)
+
+// GenerateKey writes a new private key for alg into dir and returns its kid.
+// Being the newest key it becomes the signing key KEY_ACTIVATION_DELAY later.
+func GenerateKey(dir string, alg string) (string, error) {
+	var privateKey crypto.Signer
+	var err error
+
+	switch alg {
+	case ALG_RS256:
+		privateKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	case ALG_EDDSA:
+		_, privateKey, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return "", fmt.Errorf("unsupported algorithm %q, use %s or %s", alg, ALG_RS256, ALG_EDDSA)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+
+	kid := time.Now().UTC().Format(kidTimeFormat) + "-" + strings.ToLower(alg)
+	file, err := os.OpenFile(filepath.Join(dir, kid+".pem"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	if err := pem.Encode(file, &pem.Block{Type: "PRIVATE KEY", Bytes: der}); err != nil {
+		return "", err
+	}
+	return kid, nil
+}
+
+// Prune deletes the keys in dir that stopped signing more than retain ago,
+// which is when the next newer key took over. retain is raised to
+// MAX_TOKEN_TTL, tokens signed with a key keep verifying until they expire.
+// The signing key and the keys after it are always kept.
+func Prune(dir string, retain time.Duration) ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []string{}
+	for _, path := range paths {
+		ids = append(ids, strings.TrimSuffix(filepath.Base(path), ".pem"))
+	}
+	sort.Strings(ids)
+
+	removed := []string{}
+	for _, kid := range prunable(ids, time.Now(), retain) {
+		if err := os.Remove(filepath.Join(dir, kid+".pem")); err != nil {
+			return removed, err
+		}
+		removed = append(removed, kid)
+	}
+	return removed, nil
+}
+
+// prunable returns the kids of ids, sorted oldest first, that stopped signing
+// more than retain ago at now.
+func prunable(ids []string, now time.Time, retain time.Duration) []string {
+	if retain < MAX_TOKEN_TTL {
+		retain = MAX_TOKEN_TTL
+	}
+
+	signing := signingKidAt(ids, now)
+	stale := []string{}
+	for i, kid := range ids {
+		if kid >= signing {
+			break
+		}
+		// A key the next one cannot be dated against is left alone.
+		next, err := kidCreated(ids[i+1])
+		if err != nil {
+			continue
+		}
+		retired := next.Add(KEY_ACTIVATION_DELAY)
+		if now.Sub(retired) >= retain {
+			stale = append(stale, kid)
+		}
+	}
+	return stale
+}
+
+// signingKidAt picks the key that signs at now from ids, sorted oldest first:
+// the newest one that was published for KEY_ACTIVATION_DELAY. Kids that are
+// not timestamps count as published long ago. When no key is old enough yet,
+// as right after the first key was created, the oldest one signs.
+func signingKidAt(ids []string, now time.Time) string {
+	if len(ids) == 0 {
+		return ""
+	}
+	for i := len(ids) - 1; i >= 0; i-- {
+		created, err := kidCreated(ids[i])
+		if err != nil || !now.Before(created.Add(KEY_ACTIVATION_DELAY)) {
+			return ids[i]
+		}
+	}
+	return ids[0]
+}
+
+func kidCreated(kid string) (time.Time, error) {
+	return time.Parse(kidTimeFormat, strings.SplitN(kid, "-", 2)[0])
+}
@@ -0,0 +1,135 @@
+package keystore
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func kidAt(t time.Time) string {
+	return t.UTC().Format(kidTimeFormat) + "-rs256"
+}
+
+func TestSigningKidAt(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	old := kidAt(now.Add(-30 * 24 * time.Hour))
+	published := kidAt(now.Add(-KEY_ACTIVATION_DELAY))
+	fresh := kidAt(now.Add(-time.Minute))
+
+	tests := []struct {
+		name string
+		ids  []string
+		want string
+	}{
+		{"no keys", nil, ""},
+		{"single fresh key signs right away", []string{fresh}, fresh},
+		{"fresh key waits until it was published", []string{old, fresh}, old},
+		{"key signs once the delay passed", []string{old, published}, published},
+		{"newest published key wins", []string{old, published, fresh}, published},
+		{"kids that are no timestamps count as published", []string{"legacy"}, "legacy"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := signingKidAt(test.ids, now); got != test.want {
+				t.Errorf("signingKidAt() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPrunable(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+
+	tests := []struct {
+		name   string
+		ids    []string
+		retain time.Duration
+		want   []string
+	}{
+		{
+			// Rotated weekly: the previous key is older than retain but only
+			// stopped signing yesterday, its refresh tokens are still live.
+			name:   "retention starts when the next key took over",
+			ids:    []string{kidAt(now.Add(-20 * day)), kidAt(now.Add(-day))},
+			retain: 8 * day,
+			want:   []string{},
+		},
+		{
+			name:   "key retired longer than retain ago",
+			ids:    []string{kidAt(now.Add(-30 * day)), kidAt(now.Add(-20 * day)), kidAt(now.Add(-day))},
+			retain: 8 * day,
+			want:   []string{kidAt(now.Add(-30 * day))},
+		},
+		{
+			name:   "retain below the refresh token lifetime is raised",
+			ids:    []string{kidAt(now.Add(-10 * day)), kidAt(now.Add(-3 * day))},
+			retain: time.Hour,
+			want:   []string{},
+		},
+		{
+			name:   "retain of the refresh token lifetime",
+			ids:    []string{kidAt(now.Add(-10 * day)), kidAt(now.Add(-MAX_TOKEN_TTL - KEY_ACTIVATION_DELAY))},
+			retain: 0,
+			want:   []string{kidAt(now.Add(-10 * day))},
+		},
+		{
+			// The newest key is not published long enough, so the one before
+			// it still signs and must stay.
+			name:   "signing key is kept while the newest waits",
+			ids:    []string{kidAt(now.Add(-30 * day)), kidAt(now.Add(-time.Minute))},
+			retain: 8 * day,
+			want:   []string{},
+		},
+		{
+			name:   "single key is kept",
+			ids:    []string{kidAt(now.Add(-365 * day))},
+			retain: 8 * day,
+			want:   []string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := prunable(test.ids, now, test.retain); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("prunable() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPruneKeepsPreviousKey(t *testing.T) {
+	dir := t.TempDir()
+
+	previous, err := GenerateKey(dir, ALG_EDDSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Backdate the previous key as if it had signed for a month.
+	backdated := time.Now().Add(-30*24*time.Hour).UTC().Format(kidTimeFormat) + "-eddsa"
+	if err := os.Rename(filepath.Join(dir, previous+".pem"), filepath.Join(dir, backdated+".pem")); err != nil {
+		t.Fatal(err)
+	}
+
+	newest, err := GenerateKey(dir, ALG_EDDSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := Prune(dir, 192*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 0 {
+		t.Errorf("Prune() removed %v, want nothing", removed)
+	}
+
+	for _, kid := range []string{backdated, newest} {
+		if _, err := os.Stat(filepath.Join(dir, kid+".pem")); err != nil {
+			t.Errorf("key %s was removed: %v", kid, err)
+		}
+	}
+}
@@ -45,11 +45,13 @@ package main
 
 import (
 	"golang-restaurant-management/database"
+	"golang-restaurant-management/keystore"
 	"golang-restaurant-management/middleware"
 	"golang-restaurant-management/routes"
 	"golang-restaurant-management/tasks"
 	"log"
 	"os"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
@@ -66,6 +68,15 @@ func main() {
 		log.Fatalf("Error loading .env file: %v", err)
 	}
 
+	if err := keystore.Load(os.Getenv("JWT_KEY_DIR")); err != nil {
+		log.Fatalf("Error loading signing keys: %v", err)
+	}
+	go keystore.Reload(time.Minute)
+
+	if err := tasks.LoadOTPKey(); err != nil {
+		log.Fatalf("Error loading OTP key: %v", err)
+	}
+
 	go tasks.ProcessEmailQueue()
 	port := os.Getenv("PORT")
 
@@ -77,6 +88,10 @@ func main() {
 	router.Use(gin.Logger())
 	routes.UserRoutes(router)
 	routes.HomeRoutes(router)
+	routes.TerminalRoutes(router)
+	// The kitchen feed authenticates itself, browsers cannot send headers
+	// with EventSource and WebSocket requests.
+	routes.KitchenRoutes(router)
 	router.Static("/assets", "./assets")
 	router.LoadHTMLGlob("templates/*")
 	router.Use(middleware.Authentication())
@@ -86,7 +101,12 @@ func main() {
 	routes.TableRoutes(router)
 	routes.OrderRoutes(router)
 	routes.OrderItemRoutes(router)
+	routes.StationRoutes(router)
+	routes.NoteRoutes(router)
+	routes.ReportRoutes(router)
 	routes.InvoiceRoutes(router)
+	routes.SettingRoutes(router)
+	routes.ApiKeyRoutes(router)
 
 	router.Run("0.0.0.0:" + port)
 }
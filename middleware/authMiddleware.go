@@ -3,12 +3,71 @@ package middleware
 import (
 	"fmt"
 	helper "golang-restaurant-management/helpers"
+	"golang-restaurant-management/models"
+	"golang-restaurant-management/tasks"
+	"log"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 )
 
+// Authentication accepts either an access token in the token header or an
+// api key in the X-API-Key header. Both put an equivalent principal into the
+// context, principal_type tells them apart.
 func Authentication() gin.HandlerFunc {
+	tokenAuthentication := authenticate(helper.ACCESS_TOKEN, helper.PIN_TOKEN)
+	return func(c *gin.Context) {
+		if c.Request.Header.Get("X-API-Key") != "" {
+			authenticateAPIKey(c)
+			return
+		}
+		tokenAuthentication(c)
+	}
+}
+
+// FeedAuthentication authenticates the kitchen feed. Browsers cannot set
+// headers on EventSource and WebSocket requests, so besides the token and
+// X-API-Key headers it takes a ticket from POST /kitchen/feed/tickets in the
+// ticket query. The credential is kept in the context as kitchen_credential
+// so the feed can check it again while it runs.
+func FeedAuthentication() gin.HandlerFunc {
+	authentication := Authentication()
+	return func(c *gin.Context) {
+		if ticket := c.Query("ticket"); ticket != "" {
+			credential, err := tasks.TakeKitchenTicket(ticket)
+			if err != nil {
+				if err == tasks.ErrKitchenTicketNotFound {
+					c.JSON(http.StatusUnauthorized, gin.H{"error": "the feed ticket is invalid or has expired"})
+				} else {
+					log.Printf("Error redeeming feed ticket: %v", err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to verify the feed ticket"})
+				}
+				c.Abort()
+				return
+			}
+			if credential.Token != "" {
+				c.Request.Header.Set("token", credential.Token)
+			}
+			if credential.Api_key != "" {
+				c.Request.Header.Set("X-API-Key", credential.Api_key)
+			}
+		}
+
+		c.Set("kitchen_credential", tasks.KitchenCredential{
+			Token:   c.Request.Header.Get("token"),
+			Api_key: c.Request.Header.Get("X-API-Key"),
+		})
+		authentication(c)
+	}
+}
+
+// EnrollmentAuthentication also accepts the enrollment token handed out by
+// Login when the role of the user requires 2FA but none is set up yet.
+func EnrollmentAuthentication() gin.HandlerFunc {
+	return authenticate(helper.ACCESS_TOKEN, helper.TWO_FACTOR_ENROLLMENT_TOKEN)
+}
+
+func authenticate(tokenTypes ...string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		clientToken := c.Request.Header.Get("token")
 		if clientToken == "" {
@@ -24,11 +83,139 @@ func Authentication() gin.HandlerFunc {
 			return
 		}
 
+		allowed := false
+		for _, tokenType := range tokenTypes {
+			if claims.Token_type == tokenType {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "an access token is required"})
+			c.Abort()
+			return
+		}
+
+		if claims.Token_type == helper.PIN_TOKEN && !checkPinToken(c, claims) {
+			return
+		}
+
 		c.Set("email", claims.Email)
 		c.Set("first_name", claims.First_name)
 		c.Set("last_name", claims.Last_name)
 		c.Set("uid", claims.Uid)
+		c.Set("role", claims.Role)
+		c.Set("jti", claims.ID)
+		c.Set("session_id", claims.Session_id)
+		c.Set("expires_at", claims.ExpiresAt.Unix())
+		c.Set("token_type", claims.Token_type)
+		c.Set("principal_type", "user")
+		c.Set("terminal_id", claims.Terminal_id)
+		c.Set("scopes", claims.Scopes)
+
+		helper.TouchSession(claims.Session_id)
+
+		c.Next()
+	}
+}
+
+// checkPinToken limits a PIN token to the floor scopes and locks it once the
+// terminal was idle for too long. Every request counts as activity.
+func checkPinToken(c *gin.Context, claims *helper.SignedDetails) bool {
+	resource, action := helper.RequestScope(c.FullPath(), c.Request.Method)
+	if !helper.ScopesAllow(claims.Scopes, resource, action) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "a full login is required for this action"})
+		c.Abort()
+		return false
+	}
+
+	active, err := tasks.TouchTerminalActivity(claims.ID, helper.PIN_IDLE_TIMEOUT)
+	if err != nil {
+		log.Printf("Error checking terminal activity: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to verify the token"})
+		c.Abort()
+		return false
+	}
+	if !active {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "the terminal is locked, log in with your PIN again"})
+		c.Abort()
+		return false
+	}
+	return true
+}
+
+// TerminalAuthentication only lets requests through that carry the api key
+// of the terminal named in the path.
+func TerminalAuthentication() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		apiKey, err := helper.ValidateAPIKey(c.Request.Header.Get("X-API-Key"))
+		if err != "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err})
+			c.Abort()
+			return
+		}
+
+		if apiKey.Terminal_id == nil || *apiKey.Terminal_id != c.Param("terminal_id") {
+			c.JSON(http.StatusForbidden, gin.H{"error": "this api key does not belong to the terminal"})
+			c.Abort()
+			return
+		}
+
+		c.Set("api_key_id", apiKey.Api_key_id)
+		c.Set("terminal_id", *apiKey.Terminal_id)
+
+		helper.TouchAPIKey(apiKey.Api_key_id)
 
 		c.Next()
 	}
 }
+
+func authenticateAPIKey(c *gin.Context) {
+	apiKey, err := helper.ValidateAPIKey(c.Request.Header.Get("X-API-Key"))
+	if err != "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err})
+		c.Abort()
+		return
+	}
+
+	resource, action := helper.RequestScope(c.FullPath(), c.Request.Method)
+	if !helper.APIKeyAllows(apiKey.Scopes, resource, action) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "the api key is not allowed to perform this action"})
+		c.Abort()
+		return
+	}
+
+	c.Set("first_name", *apiKey.Name)
+	c.Set("uid", "api_key:"+apiKey.Api_key_id)
+	c.Set("role", *apiKey.Role)
+	c.Set("principal_type", "api_key")
+	c.Set("api_key_id", apiKey.Api_key_id)
+	c.Set("scopes", apiKey.Scopes)
+
+	helper.TouchAPIKey(apiKey.Api_key_id)
+
+	c.Next()
+}
+
+// Authorize only lets the request through when the authenticated user holds
+// one of the given roles. Admins are always allowed. It must run after
+// Authentication, which puts the role from the token into the context.
+func Authorize(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role := c.GetString("role")
+		if role == models.ROLE_ADMIN {
+			c.Next()
+			return
+		}
+
+		for _, allowed := range roles {
+			if role == allowed {
+				c.Next()
+				return
+			}
+		}
+
+		c.JSON(http.StatusForbidden, gin.H{"error": "you are not allowed to perform this action"})
+		c.Abort()
+	}
+}
@@ -0,0 +1,122 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"golang-restaurant-management/tasks"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	DEFAULT_IDEMPOTENCY_WINDOW = 24 * time.Hour
+	IDEMPOTENCY_LOCK_TIMEOUT   = 2 * time.Minute
+)
+
+// idempotencyWriter keeps a copy of the response so it can be replayed.
+type idempotencyWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *idempotencyWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *idempotencyWriter) WriteString(data string) (int, error) {
+	w.body.WriteString(data)
+	return w.ResponseWriter.WriteString(data)
+}
+
+// Idempotency makes retried POSTs safe. The first response to a request with
+// an Idempotency-Key header is kept for IDEMPOTENCY_WINDOW and replayed for
+// repeats with the same key and body, a different body under the same key is
+// a conflict. Keys are per caller and endpoint, requests without one are
+// handled as usual. Server errors are not kept so the request can be retried.
+func Idempotency() gin.HandlerFunc {
+	window := DEFAULT_IDEMPOTENCY_WINDOW
+	if configured, err := time.ParseDuration(os.Getenv("IDEMPOTENCY_WINDOW")); err == nil && configured > 0 {
+		window = configured
+	}
+
+	return func(c *gin.Context) {
+		idempotencyKey := c.GetHeader("Idempotency-Key")
+		if idempotencyKey == "" {
+			c.Next()
+			return
+		}
+		if len(idempotencyKey) > 255 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "the Idempotency-Key header is too long"})
+			c.Abort()
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "the request body could not be read"})
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		sum := sha256.Sum256(body)
+		bodyHash := hex.EncodeToString(sum[:])
+		key := c.GetString("uid") + ":" + c.Request.Method + ":" + c.FullPath() + ":" + idempotencyKey
+
+		reserved, stored, err := tasks.ReserveIdempotencyKey(key, bodyHash, IDEMPOTENCY_LOCK_TIMEOUT)
+		if err != nil {
+			// Taking orders matters more than catching a duplicate.
+			log.Printf("idempotency key could not be checked, handling the request anyway: %v", err)
+			c.Next()
+			return
+		}
+
+		if !reserved {
+			if stored.Body_hash != bodyHash {
+				c.JSON(http.StatusConflict, gin.H{"error": "the Idempotency-Key was already used for a different request"})
+				c.Abort()
+				return
+			}
+			if !stored.Completed() {
+				c.JSON(http.StatusConflict, gin.H{"error": "a request with this Idempotency-Key is still being processed"})
+				c.Abort()
+				return
+			}
+
+			c.Header("Idempotent-Replayed", "true")
+			c.Data(stored.Status, stored.Content_type, stored.Body)
+			c.Abort()
+			return
+		}
+
+		writer := &idempotencyWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+
+		c.Next()
+
+		status := writer.Status()
+		if status >= http.StatusInternalServerError {
+			if err := tasks.ReleaseIdempotencyKey(key); err != nil {
+				log.Printf("failed to release idempotency key: %v", err)
+			}
+			return
+		}
+
+		err = tasks.SaveIdempotentResponse(key, tasks.IdempotentResponse{
+			Body_hash:    bodyHash,
+			Status:       status,
+			Content_type: writer.Header().Get("Content-Type"),
+			Body:         writer.body.Bytes(),
+		}, window)
+		if err != nil {
+			log.Printf("failed to save idempotent response: %v", err)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Scopes of an api key are written as "<resource>:<read|write>", e.g.
+// "orders:read", or "*" for every resource. The resource is the first segment
+// of the route path. Account and admin endpoints are never reachable with an
+// api key.
+const API_KEY_SCOPE_ALL = "*"
+
+var API_KEY_RESOURCES = []string{"foods", "menus", "tables", "orders", "orderItems", "invoices", "kitchen", "stations", "notes", "reports"}
+
+type ApiKey struct {
+	ID     primitive.ObjectID `bson:"_id"`
+	Name   *string            `json:"name" validate:"required,min=2,max=100"`
+	Role   *string            `json:"role" validate:"required,eq=MANAGER|eq=WAITER|eq=KITCHEN|eq=CASHIER"`
+	Scopes []string           `json:"scopes" validate:"required,min=1"`
+	// Set when the key belongs to a registered terminal, which then allows
+	// PIN logins on it.
+	Terminal_id  *string    `json:"terminal_id" validate:"omitempty,min=1,max=64"`
+	Key_prefix   string     `json:"key_prefix"`
+	Key_hash     string     `json:"-"`
+	Expires_at   *time.Time `json:"expires_at"`
+	Last_used_at *time.Time `json:"last_used_at"`
+	Created_by   string     `json:"created_by"`
+	Created_at   time.Time  `json:"created_at"`
+	Updated_at   time.Time  `json:"updated_at"`
+	Api_key_id   string     `json:"api_key_id"`
+}
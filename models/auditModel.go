@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	AUDIT_ROLE_ASSIGNED    = "ROLE_ASSIGNED"
+	AUDIT_USER_DEACTIVATED = "USER_DEACTIVATED"
+	AUDIT_USER_REACTIVATED = "USER_REACTIVATED"
+	AUDIT_USER_EXPORTED    = "USER_EXPORTED"
+	AUDIT_USER_ERASED      = "USER_ERASED"
+	AUDIT_SESSIONS_REVOKED = "SESSIONS_REVOKED"
+	AUDIT_TWO_FACTOR_RESET = "TWO_FACTOR_RESET"
+	AUDIT_INVITATION_SENT  = "INVITATION_SENT"
+	AUDIT_PASSWORD_CHANGED = "PASSWORD_CHANGED"
+	AUDIT_EMAIL_CHANGED    = "EMAIL_CHANGED"
+)
+
+// AuditEntry records who did what to whom. Details must never hold personal
+// data, erasing a user only clears the IP address of their own actions.
+type AuditEntry struct {
+	ID          primitive.ObjectID     `bson:"_id"`
+	Actor_id    string                 `json:"actor_id"`
+	Action      string                 `json:"action"`
+	Target_type string                 `json:"target_type"`
+	Target_id   string                 `json:"target_id"`
+	Ip_address  string                 `json:"ip_address"`
+	Details     map[string]interface{} `json:"details"`
+	Created_at  time.Time              `json:"created_at"`
+	Audit_id    string                 `json:"audit_id"`
+}
@@ -6,13 +6,33 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// ModifierGroup is a choice offered with a food, like its size, how it is
+// cooked or extra toppings. A required group needs at least one selection
+// and never less than Min_selections, Max_selections of 0 means no limit.
+type ModifierGroup struct {
+	Group_id       string           `json:"group_id"`
+	Name           string           `json:"name" validate:"required,min=1,max=50"`
+	Required       bool             `json:"required"`
+	Min_selections int              `json:"min_selections" validate:"min=0"`
+	Max_selections int              `json:"max_selections" validate:"min=0"`
+	Options        []ModifierOption `json:"options" validate:"required,min=1,dive"`
+}
+
+type ModifierOption struct {
+	Option_id   string  `json:"option_id"`
+	Name        string  `json:"name" validate:"required,min=1,max=50"`
+	Price_delta float64 `json:"price_delta"`
+}
+
 type Food struct {
-	ID         primitive.ObjectID `bson:"_id"`
-	Name       *string            `json:"name" validate:"required,min=2,max=100"`
-	Price      *float64           `json:"price" validate:"required"`
-	Food_image *string            `json:"food_image" validate:"required"`
-	Created_at time.Time          `json:"created_at"`
-	Updated_at time.Time          `json:"updated_at"`
-	Food_id    string             `json:"food_id"`
-	Menu_id    *string            `json:"menu_id" validate:"required"`
+	ID              primitive.ObjectID `bson:"_id"`
+	Name            *string            `json:"name" validate:"required,min=2,max=100"`
+	Price           *float64           `json:"price" validate:"required"`
+	Food_image      *string            `json:"food_image" validate:"required"`
+	Created_at      time.Time          `json:"created_at"`
+	Updated_at      time.Time          `json:"updated_at"`
+	Food_id         string             `json:"food_id"`
+	Menu_id         *string            `json:"menu_id" validate:"required"`
+	Station_id      *string            `json:"station_id"`
+	Modifier_groups []ModifierGroup    `json:"modifier_groups" validate:"omitempty,dive"`
 }
@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	INVITATION_PENDING  = "PENDING"
+	INVITATION_ACCEPTED = "ACCEPTED"
+	INVITATION_REVOKED  = "REVOKED"
+)
+
+type Invitation struct {
+	ID         primitive.ObjectID `bson:"_id"`
+	Email      *string            `json:"email" validate:"email,required"`
+	Role       *string            `json:"role" validate:"required,eq=ADMIN|eq=MANAGER|eq=WAITER|eq=KITCHEN|eq=CASHIER"`
+	First_name *string            `json:"first_name" validate:"omitempty,min=2,max=100"`
+	Last_name  *string            `json:"last_name" validate:"omitempty,min=2,max=100"`
+	Status     string             `json:"status"`
+	// Id of the last invite token sent, older links stop working when the
+	// invitation is sent again.
+	Token_id      string     `json:"-"`
+	Invited_by    string     `json:"invited_by"`
+	Expires_at    time.Time  `json:"expires_at"`
+	Accepted_at   *time.Time `json:"accepted_at"`
+	Created_at    time.Time  `json:"created_at"`
+	Updated_at    time.Time  `json:"updated_at"`
+	Invitation_id string     `json:"invitation_id"`
+}
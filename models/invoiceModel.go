@@ -6,6 +6,14 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+const (
+	INVOICE_PENDING = "PENDING"
+	INVOICE_PAID    = "PAID"
+	INVOICE_VOID    = "VOID"
+)
+
+// Superseded_by is the order whose invoice replaced this one when their
+// orders were merged. Only the merge voids invoices, clients cannot set VOID.
 type Invoice struct {
 	ID               primitive.ObjectID `bson:"_id"`
 	Invoice_id       string             `json:"invoice_id"`
@@ -13,6 +21,7 @@ type Invoice struct {
 	Payment_method   *string            `json:"payment_method" validate:"eq=CARD|eq=CASH|eq="`
 	Payment_status   *string            `json:"payment_status" validate:"required,eq=PENDING|eq=PAID" Default:"PENDING"`
 	Payment_due_date time.Time          `json:"Payment_due_date"`
+	Superseded_by    *string            `json:"superseded_by,omitempty"`
 	Created_at       time.Time          `json:"created_at"`
 	Updated_at       time.Time          `json:"updated_at"`
 }
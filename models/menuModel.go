@@ -13,4 +13,5 @@ type Menu struct {
 	Created_at time.Time          `bson:"created_at" json:"created_at"`
 	Updated_at time.Time          `bson:"updated_at" json:"updated_at"`
 	Menu_id    string             `bson:"menu_id" json:"menu_id"`
+	Station_id *string            `bson:"station_id,omitempty" json:"station_id"`
 }
@@ -6,11 +6,33 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// Things a note can be attached to. Customers have no record of their own,
+// their target id is whatever identifies them to the staff.
+const (
+	NOTE_TARGET_ORDER      = "ORDER"
+	NOTE_TARGET_ORDER_ITEM = "ORDER_ITEM"
+	NOTE_TARGET_TABLE      = "TABLE"
+	NOTE_TARGET_CUSTOMER   = "CUSTOMER"
+)
+
+// Who gets to see a note. Kitchen notes are for the kitchen only and front of
+// house notes for the waiters and cashiers, managers see everything.
+const (
+	NOTE_VISIBILITY_ALL            = "ALL"
+	NOTE_VISIBILITY_KITCHEN        = "KITCHEN"
+	NOTE_VISIBILITY_FRONT_OF_HOUSE = "FRONT_OF_HOUSE"
+)
+
 type Note struct {
-	ID         primitive.ObjectID `bson:"_id"`
-	Text       string             `json:"text"`
-	Title      string             `json:"title"`
-	Created_at time.Time          `json:"created_at"`
-	Updated_at time.Time          `json:"updated_at"`
-	Note_id    string             `json:"note_id"`
+	ID          primitive.ObjectID `bson:"_id"`
+	Text        string             `json:"text" validate:"required,min=1,max=1000"`
+	Title       string             `json:"title" validate:"max=100"`
+	Target_type *string            `json:"target_type" validate:"required,eq=ORDER|eq=ORDER_ITEM|eq=TABLE|eq=CUSTOMER"`
+	Target_id   *string            `json:"target_id" validate:"required,min=1,max=100"`
+	Visibility  string             `json:"visibility" validate:"omitempty,eq=ALL|eq=KITCHEN|eq=FRONT_OF_HOUSE"`
+	Author_id   string             `json:"author_id"`
+	Author_name string             `json:"author_name"`
+	Created_at  time.Time          `json:"created_at"`
+	Updated_at  time.Time          `json:"updated_at"`
+	Note_id     string             `json:"note_id"`
 }
@@ -1,19 +1,107 @@
 package models
 
 import (
+	"math"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+const (
+	PREP_QUEUED  = "QUEUED"
+	PREP_COOKING = "COOKING"
+	PREP_DONE    = "DONE"
+)
+
+// PREP_NEXT is the status a station bumps an item to from each status.
+var PREP_NEXT = map[string]string{
+	PREP_QUEUED:  PREP_COOKING,
+	PREP_COOKING: PREP_DONE,
+}
+
+// SelectedModifier is an option picked for an order item. Clients send the
+// group and option ids, the names and the price delta are copied from the
+// food when the item is ordered.
+type SelectedModifier struct {
+	Group_id    string  `json:"group_id" validate:"required"`
+	Option_id   string  `json:"option_id" validate:"required"`
+	Group_name  string  `json:"group_name"`
+	Name        string  `json:"name"`
+	Price_delta float64 `json:"price_delta"`
+}
+
+// Share is the part of the line billed on this order when it was split
+// between checks, nil meaning all of it. Split_from is the item it was split
+// off from. Billing_only items are shares the kitchen does not see, it
+// prepares the whole line through the item they were split from.
 type OrderItem struct {
-	ID            primitive.ObjectID `bson:"_id"`
-	Quantity      *int               `json:"quantity" validate:"required,eq=S|eq=M|eq=L"`
-	Unit_price    *float64           `json:"unit_price" validate:"required"`
-	Created_at    time.Time          `json:"created_at"`
-	Updated_at    time.Time          `json:"updated_at"`
-	Food_id       *string            `json:"food_id" validate:"required"`
-	Order_item_id string             `json:"order_item_id"`
-	Order_id      string             `json:"order_id" validate:"required"`
-	Total_price   *float64           `json:"total_price"`
+	ID              primitive.ObjectID `bson:"_id"`
+	Quantity        *int               `json:"quantity" validate:"required,min=1"`
+	Unit_price      *float64           `json:"unit_price" validate:"required"`
+	Created_at      time.Time          `json:"created_at"`
+	Updated_at      time.Time          `json:"updated_at"`
+	Food_id         *string            `json:"food_id" validate:"required"`
+	Order_item_id   string             `json:"order_item_id"`
+	Order_id        string             `json:"order_id" validate:"required"`
+	Total_price     *float64           `json:"total_price"`
+	Station_id      *string            `json:"station_id"`
+	Prep_status     string             `json:"prep_status"`
+	Prep_started_at *time.Time         `json:"prep_started_at"`
+	Prep_done_at    *time.Time         `json:"prep_done_at"`
+	Modifiers       []SelectedModifier `json:"modifiers"`
+	Void            *VoidRecord        `json:"void"`
+	Share           *float64           `json:"share"`
+	Split_from      *string            `json:"split_from"`
+	Billing_only    bool               `json:"billing_only"`
+}
+
+// LineTotal is the price of an item line, taking a split share into account.
+func LineTotal(orderItem OrderItem) float64 {
+	total := *orderItem.Unit_price * float64(*orderItem.Quantity)
+	if orderItem.Share != nil {
+		total *= *orderItem.Share
+	}
+	return roundCents(total)
+}
+
+// SplitOrderItem splits quantity off an item, or a share of its price, and
+// returns what remains of the item and the part that was split off. The part
+// is a copy the caller still gives its own ids, and both totals add up to the
+// total before the split. A share part is billing only.
+func SplitOrderItem(orderItem OrderItem, quantity *int, share *float64) (OrderItem, OrderItem) {
+	before := LineTotal(orderItem)
+	part := orderItem
+
+	if share != nil {
+		current := 1.0
+		if orderItem.Share != nil {
+			current = *orderItem.Share
+		}
+		remainingShare := current * (1 - *share)
+		partShare := current * *share
+		orderItem.Share = &remainingShare
+		part.Share = &partShare
+
+		part.Billing_only = true
+		part.Station_id = nil
+		part.Prep_status = PREP_DONE
+		part.Prep_started_at = nil
+		part.Prep_done_at = nil
+	} else {
+		remainingQuantity := *orderItem.Quantity - *quantity
+		partQuantity := *quantity
+		orderItem.Quantity = &remainingQuantity
+		part.Quantity = &partQuantity
+	}
+
+	remainingTotal := LineTotal(orderItem)
+	partTotal := roundCents(before - remainingTotal)
+	orderItem.Total_price = &remainingTotal
+	part.Total_price = &partTotal
+
+	return orderItem, part
+}
+
+func roundCents(amount float64) float64 {
+	return math.Round(amount*100) / 100
 }
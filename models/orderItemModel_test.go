@@ -0,0 +1,136 @@
+package models
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSplitOrderItem(t *testing.T) {
+	intPtr := func(value int) *int { return &value }
+	floatPtr := func(value float64) *float64 { return &value }
+	station := "grill"
+
+	tests := []struct {
+		name                  string
+		quantity              int
+		unitPrice             float64
+		itemShare             *float64
+		splitQuantity         *int
+		splitShare            *float64
+		wantRemaining         float64
+		wantPart              float64
+		wantPartQuantity      int
+		wantRemainingQuantity int
+		wantPartShare         *float64
+		wantRemainingShare    *float64
+	}{
+		{
+			name:                  "quantity",
+			quantity:              3,
+			unitPrice:             4.5,
+			splitQuantity:         intPtr(1),
+			wantRemaining:         9,
+			wantPart:              4.5,
+			wantPartQuantity:      1,
+			wantRemainingQuantity: 2,
+		},
+		{
+			name:                  "half share",
+			quantity:              1,
+			unitPrice:             12,
+			splitShare:            floatPtr(0.5),
+			wantRemaining:         6,
+			wantPart:              6,
+			wantPartQuantity:      1,
+			wantRemainingQuantity: 1,
+			wantPartShare:         floatPtr(0.5),
+			wantRemainingShare:    floatPtr(0.5),
+		},
+		{
+			name:                  "third share rounds the part to the cent",
+			quantity:              1,
+			unitPrice:             10,
+			splitShare:            floatPtr(1.0 / 3),
+			wantRemaining:         6.67,
+			wantPart:              3.33,
+			wantPartQuantity:      1,
+			wantRemainingQuantity: 1,
+			wantPartShare:         floatPtr(1.0 / 3),
+			wantRemainingShare:    floatPtr(2.0 / 3),
+		},
+		{
+			name:                  "share of a share",
+			quantity:              2,
+			unitPrice:             8,
+			itemShare:             floatPtr(0.5),
+			splitShare:            floatPtr(0.5),
+			wantRemaining:         4,
+			wantPart:              4,
+			wantPartQuantity:      2,
+			wantRemainingQuantity: 2,
+			wantPartShare:         floatPtr(0.25),
+			wantRemainingShare:    floatPtr(0.25),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			quantity := tt.quantity
+			unitPrice := tt.unitPrice
+			orderItem := OrderItem{
+				Quantity:    &quantity,
+				Unit_price:  &unitPrice,
+				Share:       tt.itemShare,
+				Station_id:  &station,
+				Prep_status: PREP_COOKING,
+			}
+			before := LineTotal(orderItem)
+
+			remaining, part := SplitOrderItem(orderItem, tt.splitQuantity, tt.splitShare)
+
+			if *remaining.Total_price != tt.wantRemaining || *part.Total_price != tt.wantPart {
+				t.Errorf("totals = %v + %v, want %v + %v", *remaining.Total_price, *part.Total_price, tt.wantRemaining, tt.wantPart)
+			}
+			if sum := roundCents(*remaining.Total_price + *part.Total_price); sum != before {
+				t.Errorf("totals add up to %v, want %v", sum, before)
+			}
+			if *remaining.Quantity != tt.wantRemainingQuantity || *part.Quantity != tt.wantPartQuantity {
+				t.Errorf("quantities = %d + %d, want %d + %d", *remaining.Quantity, *part.Quantity, tt.wantRemainingQuantity, tt.wantPartQuantity)
+			}
+			if !sameShare(part.Share, tt.wantPartShare) || !sameShare(remaining.Share, tt.wantRemainingShare) {
+				t.Errorf("shares = %v + %v, want %v + %v", remaining.Share, part.Share, tt.wantRemainingShare, tt.wantPartShare)
+			}
+
+			if remaining.Billing_only || remaining.Station_id == nil || remaining.Prep_status != PREP_COOKING {
+				t.Errorf("remaining item left the kitchen: %+v", remaining)
+			}
+			if tt.splitShare != nil {
+				if !part.Billing_only || part.Station_id != nil || part.Prep_status != PREP_DONE {
+					t.Errorf("share part is not billing only: %+v", part)
+				}
+			} else if part.Billing_only || part.Station_id == nil || part.Prep_status != PREP_COOKING {
+				t.Errorf("quantity part left the kitchen: %+v", part)
+			}
+		})
+	}
+}
+
+func TestSplitOrderItemKeepsTheOriginal(t *testing.T) {
+	quantity := 4
+	unitPrice := 2.5
+	orderItem := OrderItem{Quantity: &quantity, Unit_price: &unitPrice}
+	split := 1
+
+	SplitOrderItem(orderItem, &split, nil)
+
+	if *orderItem.Quantity != 4 || orderItem.Total_price != nil {
+		t.Errorf("SplitOrderItem changed the item it was given: %+v", orderItem)
+	}
+}
+
+func sameShare(got *float64, want *float64) bool {
+	if got == nil || want == nil {
+		return got == want
+	}
+	return math.Abs(*got-*want) < 1e-9
+}
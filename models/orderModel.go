@@ -6,11 +6,136 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+const (
+	ORDER_PLACED         = "PLACED"
+	ORDER_ACCEPTED       = "ACCEPTED"
+	ORDER_IN_PREPARATION = "IN_PREPARATION"
+	ORDER_READY          = "READY"
+	ORDER_SERVED         = "SERVED"
+	ORDER_CLOSED         = "CLOSED"
+	ORDER_CANCELLED      = "CANCELLED"
+	ORDER_MERGED         = "MERGED"
+)
+
+// ORDER_TRANSITIONS lists the statuses an order may move to from each status.
+// CLOSED, CANCELLED and MERGED, for orders whose items were moved into
+// another order, are final.
+var ORDER_TRANSITIONS = map[string][]string{
+	ORDER_PLACED:         {ORDER_ACCEPTED, ORDER_CANCELLED, ORDER_MERGED},
+	ORDER_ACCEPTED:       {ORDER_IN_PREPARATION, ORDER_CANCELLED, ORDER_MERGED},
+	ORDER_IN_PREPARATION: {ORDER_READY, ORDER_CANCELLED, ORDER_MERGED},
+	ORDER_READY:          {ORDER_SERVED, ORDER_CANCELLED, ORDER_MERGED},
+	ORDER_SERVED:         {ORDER_CLOSED, ORDER_MERGED},
+	ORDER_CLOSED:         {},
+	ORDER_CANCELLED:      {},
+	ORDER_MERGED:         {},
+}
+
+// ORDER_TRANSITION_ROLES lists who may move an order into each status, admins
+// always may.
+var ORDER_TRANSITION_ROLES = map[string][]string{
+	ORDER_ACCEPTED:       {ROLE_MANAGER, ROLE_WAITER, ROLE_KITCHEN},
+	ORDER_IN_PREPARATION: {ROLE_MANAGER, ROLE_KITCHEN},
+	ORDER_READY:          {ROLE_MANAGER, ROLE_KITCHEN},
+	ORDER_SERVED:         {ROLE_MANAGER, ROLE_WAITER},
+	ORDER_CLOSED:         {ROLE_MANAGER, ROLE_CASHIER},
+	ORDER_CANCELLED:      {ROLE_MANAGER, ROLE_WAITER},
+	ORDER_MERGED:         {ROLE_MANAGER, ROLE_WAITER, ROLE_CASHIER},
+}
+
+// OrderStatusChange is one step in the life of an order. The first entry has
+// no From, it records who placed the order.
+type OrderStatusChange struct {
+	From       string    `json:"from"`
+	To         string    `json:"to"`
+	Actor_id   string    `json:"actor_id"`
+	Reason     *string   `json:"reason"`
+	Changed_at time.Time `json:"changed_at"`
+}
+
+const (
+	ORDER_MOVE_TRANSFER = "TRANSFER"
+	ORDER_MOVE_MERGE    = "MERGE"
+	ORDER_MOVE_SPLIT    = "SPLIT"
+)
+
+// OrderMove records a transfer of an order to another table, or items moving
+// between orders when orders are merged or split. Order_id is the other
+// order involved.
+type OrderMove struct {
+	Type           string    `json:"type"`
+	From_table_id  *string   `json:"from_table_id"`
+	To_table_id    *string   `json:"to_table_id"`
+	Order_id       string    `json:"order_id"`
+	Order_item_ids []string  `json:"order_item_ids"`
+	Actor_id       string    `json:"actor_id"`
+	Moved_at       time.Time `json:"moved_at"`
+}
+
+const (
+	ORDER_TYPE_DINE_IN  = "DINE_IN"
+	ORDER_TYPE_TAKEAWAY = "TAKEAWAY"
+	ORDER_TYPE_DELIVERY = "DELIVERY"
+)
+
+// Takeaway is who picks up a takeaway order and when.
+type Takeaway struct {
+	Customer_name  *string    `json:"customer_name" validate:"required,min=2,max=100"`
+	Customer_phone *string    `json:"customer_phone" validate:"required,min=5,max=20"`
+	Pickup_at      *time.Time `json:"pickup_at" validate:"required"`
+}
+
+// Delivery is where a delivery order goes and who takes it there. The fee is
+// billed on top of the items, the driver is usually assigned later.
+type Delivery struct {
+	Customer_name  *string  `json:"customer_name" validate:"required,min=2,max=100"`
+	Customer_phone *string  `json:"customer_phone" validate:"required,min=5,max=20"`
+	Address        *string  `json:"address" validate:"required,min=5,max=500"`
+	Delivery_fee   *float64 `json:"delivery_fee" validate:"omitempty,min=0"`
+	Driver_name    *string  `json:"driver_name" validate:"omitempty,max=100"`
+	Driver_phone   *string  `json:"driver_phone" validate:"omitempty,max=20"`
+}
+
+// Order is dine-in unless Type says otherwise, only dine-in orders have a
+// table and only the other types have their Takeaway or Delivery details.
 type Order struct {
-	ID         primitive.ObjectID `bson:"_id"`
-	Order_Date time.Time          `json:"order_date" validate:"required"`
-	Created_at time.Time          `json:"created_at"`
-	Updated_at time.Time          `json:"updated_at"`
-	Order_id   string             `json:"order_id"`
-	Table_id   *string            `json:"table_id" validate:"required"`
+	ID             primitive.ObjectID  `bson:"_id"`
+	Order_Date     time.Time           `json:"order_date" validate:"required"`
+	Created_at     time.Time           `json:"created_at"`
+	Updated_at     time.Time           `json:"updated_at"`
+	Order_id       string              `json:"order_id"`
+	Type           string              `json:"type" validate:"omitempty,eq=DINE_IN|eq=TAKEAWAY|eq=DELIVERY"`
+	Table_id       *string             `json:"table_id"`
+	Takeaway       *Takeaway           `json:"takeaway"`
+	Delivery       *Delivery           `json:"delivery"`
+	Created_by     string              `json:"created_by"`
+	Status         string              `json:"status"`
+	Status_history []OrderStatusChange `json:"status_history"`
+	Cancellation   *VoidRecord         `json:"cancellation"`
+	Merged_into    *string             `json:"merged_into"`
+	Split_from     *string             `json:"split_from"`
+	Moves          []OrderMove         `json:"moves"`
+}
+
+// CanTransition reports whether an order in status from may move to status to.
+func CanTransition(from string, to string) bool {
+	for _, allowed := range ORDER_TRANSITIONS[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// CanMoveOrderTo reports whether role may move an order into status.
+func CanMoveOrderTo(role string, status string) bool {
+	if role == ROLE_ADMIN {
+		return true
+	}
+	for _, allowed := range ORDER_TRANSITION_ROLES[status] {
+		if role == allowed {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,81 @@
+package models
+
+import "testing"
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		from string
+		to   string
+		want bool
+	}{
+		{ORDER_PLACED, ORDER_ACCEPTED, true},
+		{ORDER_ACCEPTED, ORDER_IN_PREPARATION, true},
+		{ORDER_IN_PREPARATION, ORDER_READY, true},
+		{ORDER_READY, ORDER_SERVED, true},
+		{ORDER_SERVED, ORDER_CLOSED, true},
+		{ORDER_PLACED, ORDER_CANCELLED, true},
+		{ORDER_READY, ORDER_CANCELLED, true},
+		{ORDER_SERVED, ORDER_MERGED, true},
+
+		{ORDER_PLACED, ORDER_READY, false},
+		{ORDER_ACCEPTED, ORDER_SERVED, false},
+		{ORDER_READY, ORDER_IN_PREPARATION, false},
+		{ORDER_SERVED, ORDER_CANCELLED, false},
+		{ORDER_PLACED, ORDER_PLACED, false},
+		{ORDER_CLOSED, ORDER_PLACED, false},
+		{ORDER_CANCELLED, ORDER_ACCEPTED, false},
+		{ORDER_MERGED, ORDER_CLOSED, false},
+		{"UNKNOWN", ORDER_ACCEPTED, false},
+		{ORDER_PLACED, "UNKNOWN", false},
+	}
+
+	for _, tt := range tests {
+		if got := CanTransition(tt.from, tt.to); got != tt.want {
+			t.Errorf("CanTransition(%s, %s) = %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestOrderTransitionsAreComplete(t *testing.T) {
+	for from, statuses := range ORDER_TRANSITIONS {
+		for _, to := range statuses {
+			if _, ok := ORDER_TRANSITIONS[to]; !ok {
+				t.Errorf("%s moves to %s, which has no transitions of its own", from, to)
+			}
+			if len(ORDER_TRANSITION_ROLES[to]) == 0 {
+				t.Errorf("nobody but admins may move an order to %s", to)
+			}
+		}
+	}
+}
+
+func TestCanMoveOrderTo(t *testing.T) {
+	tests := []struct {
+		role   string
+		status string
+		want   bool
+	}{
+		{ROLE_ADMIN, ORDER_CLOSED, true},
+		{ROLE_ADMIN, "UNKNOWN", true},
+		{ROLE_MANAGER, ORDER_CANCELLED, true},
+		{ROLE_WAITER, ORDER_ACCEPTED, true},
+		{ROLE_WAITER, ORDER_SERVED, true},
+		{ROLE_KITCHEN, ORDER_READY, true},
+		{ROLE_CASHIER, ORDER_CLOSED, true},
+		{ROLE_CASHIER, ORDER_MERGED, true},
+
+		{ROLE_WAITER, ORDER_READY, false},
+		{ROLE_WAITER, ORDER_CLOSED, false},
+		{ROLE_KITCHEN, ORDER_SERVED, false},
+		{ROLE_KITCHEN, ORDER_CANCELLED, false},
+		{ROLE_CASHIER, ORDER_ACCEPTED, false},
+		{"", ORDER_ACCEPTED, false},
+		{ROLE_MANAGER, ORDER_PLACED, false},
+	}
+
+	for _, tt := range tests {
+		if got := CanMoveOrderTo(tt.role, tt.status); got != tt.want {
+			t.Errorf("CanMoveOrderTo(%q, %s) = %v, want %v", tt.role, tt.status, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type Session struct {
+	ID                 primitive.ObjectID `bson:"_id"`
+	Session_id         string             `json:"session_id"`
+	User_id            string             `json:"user_id"`
+	User_agent         string             `json:"user_agent"`
+	Ip_address         string             `json:"ip_address"`
+	Refresh_token_hash string             `json:"-"`
+	Created_at         time.Time          `json:"created_at"`
+	Last_seen_at       time.Time          `json:"last_seen_at"`
+	Expires_at         time.Time          `json:"expires_at"`
+}
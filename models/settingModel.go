@@ -0,0 +1,15 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type TwoFactorPolicy struct {
+	ID             primitive.ObjectID `bson:"_id"`
+	Key            string             `json:"-"`
+	Required_roles []string           `json:"required_roles" validate:"dive,eq=ADMIN|eq=MANAGER|eq=WAITER|eq=KITCHEN|eq=CASHIER"`
+	Updated_by     string             `json:"updated_by"`
+	Updated_at     time.Time          `json:"updated_at"`
+}
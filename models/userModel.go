@@ -6,20 +6,45 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+const (
+	ROLE_ADMIN   = "ADMIN"
+	ROLE_MANAGER = "MANAGER"
+	ROLE_WAITER  = "WAITER"
+	ROLE_KITCHEN = "KITCHEN"
+	ROLE_CASHIER = "CASHIER"
+)
+
 type User struct {
-	ID            primitive.ObjectID `bson:"_id"`
-	First_name    *string            `json:"first_name" validate:"required,min=2,max=100"`
-	Last_name     *string            `json:"last_name" validate:"required,min=2,max=100"`
-	Password      *string            `json:"Password" validate:"required,min=6"`
-	Email         *string            `json:"email" validate:"email,required"`
-	Avatar        *string            `json:"avatar"`
-	Phone         *string            `json:"phone" validate:"required"`
-	Token         *string            `json:"token"`
-	Refresh_Token *string            `json:"refresh_token"`
-	Created_at    time.Time          `json:"created_at"`
-	Updated_at    time.Time          `json:"updated_at"`
-	User_id       string             `json:"user_id"`
-	Is_Verified   bool               `json:"is_verified"`
-	OTP           int                `json:"otp"`
-	Is_otp_valid  bool               `json:"is_otp_valid"`
+	ID           primitive.ObjectID `bson:"_id"`
+	First_name   *string            `json:"first_name" validate:"required,min=2,max=100"`
+	Last_name    *string            `json:"last_name" validate:"required,min=2,max=100"`
+	Password     *string            `json:"Password" validate:"required,min=6"`
+	Email        *string            `json:"email" validate:"email,required"`
+	Avatar       *string            `json:"avatar"`
+	Phone        *string            `json:"phone" validate:"required"`
+	Role         *string            `json:"role" validate:"omitempty,eq=ADMIN|eq=MANAGER|eq=WAITER|eq=KITCHEN|eq=CASHIER"`
+	Created_at   time.Time          `json:"created_at"`
+	Updated_at   time.Time          `json:"updated_at"`
+	User_id      string             `json:"user_id"`
+	Is_Verified  bool               `json:"is_verified"`
+	OTP          int                `json:"otp"`
+	Is_otp_valid bool               `json:"is_otp_valid"`
+	Totp_enabled bool               `json:"totp_enabled"`
+	// Deactivated users cannot log in, erased users are deactivated and had
+	// their personal data anonymised.
+	Is_deactivated bool       `json:"is_deactivated"`
+	Deactivated_at *time.Time `json:"deactivated_at"`
+	Erased_at      *time.Time `json:"erased_at"`
+	// The TOTP secret, the last accepted time step and the hashed recovery
+	// codes never leave the server.
+	Totp_secret    *string  `json:"-"`
+	Totp_last_step int64    `json:"-"`
+	Recovery_codes []string `json:"-"`
+	// bcrypt hash of the PIN used for quick logins on shared terminals.
+	Pin *string `json:"-"`
+	// The identity provider user this user is linked to, see OIDCCallback.
+	Oidc_issuer  *string `json:"-"`
+	Oidc_subject *string `json:"-"`
+	// Part of every token, see helper.BumpTokenVersion.
+	Token_version int `json:"-"`
 }
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// A voided item is taken off the bill because it should not have been
+// ordered or was never served, a comped item was served free of charge. Both
+// stay in the database for the shrinkage reports.
+const (
+	VOID_TYPE_VOID = "VOID"
+	VOID_TYPE_COMP = "COMP"
+)
+
+// VOID_REASON_CODES are the reasons an item can be voided or comped, or an
+// order cancelled for. OTHER needs a written reason.
+var VOID_REASON_CODES = []string{
+	"ORDER_ERROR",
+	"CUSTOMER_CHANGED_MIND",
+	"QUALITY_ISSUE",
+	"LONG_WAIT",
+	"KITCHEN_ERROR",
+	"OUT_OF_STOCK",
+	"WALKOUT",
+	"MANAGER_COMP",
+	"OTHER",
+}
+
+// VoidRecord says who voided or comped an item or cancelled an order, why
+// and when. Fired is set when the kitchen had already started on the item,
+// Approved_by is the manager who allowed it then.
+type VoidRecord struct {
+	Type        string    `json:"type"`
+	Reason_code string    `json:"reason_code"`
+	Reason      *string   `json:"reason"`
+	Actor_id    string    `json:"actor_id"`
+	Approved_by *string   `json:"approved_by"`
+	Fired       bool      `json:"fired"`
+	Voided_at   time.Time `json:"voided_at"`
+}
+
+// VoidPolicy decides whether staff below manager need a manager to approve
+// voids of items the kitchen already started on.
+type VoidPolicy struct {
+	ID               primitive.ObjectID `bson:"_id"`
+	Key              string             `json:"-"`
+	Require_override bool               `json:"require_override"`
+	Updated_by       string             `json:"updated_by"`
+	Updated_at       time.Time          `json:"updated_at"`
+}
+
+func ValidVoidReasonCode(code string) bool {
+	for _, allowed := range VOID_REASON_CODES {
+		if code == allowed {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,17 @@
+package routes
+
+import (
+	controller "golang-restaurant-management/controllers"
+	"golang-restaurant-management/middleware"
+	"golang-restaurant-management/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func ApiKeyRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/api-keys", middleware.Authorize(models.ROLE_ADMIN), controller.GetApiKeys())
+	incomingRoutes.GET("/api-keys/:api_key_id", middleware.Authorize(models.ROLE_ADMIN), controller.GetApiKey())
+	incomingRoutes.POST("/api-keys", middleware.Authorize(models.ROLE_ADMIN), controller.CreateApiKey())
+	incomingRoutes.PATCH("/api-keys/:api_key_id", middleware.Authorize(models.ROLE_ADMIN), controller.UpdateApiKey())
+	incomingRoutes.DELETE("/api-keys/:api_key_id", middleware.Authorize(models.ROLE_ADMIN), controller.DeleteApiKey())
+}
@@ -2,6 +2,8 @@ package routes
 
 import (
 	controller "golang-restaurant-management/controllers"
+	"golang-restaurant-management/middleware"
+	"golang-restaurant-management/models"
 
 	"github.com/gin-gonic/gin"
 )
@@ -9,8 +11,8 @@ import (
 func FoodRoutes(incomingRoutes *gin.Engine) {
 	incomingRoutes.GET("/foods", controller.GetFoods())
 	incomingRoutes.GET("/foods/:food_id", controller.GetFood())
-	incomingRoutes.POST("/foods", controller.CreateFood())
-	incomingRoutes.PATCH("/foods/:food_id", controller.UpdateFood())
-	incomingRoutes.DELETE("/foods/:food_id", controller.DeleteFood())
+	incomingRoutes.POST("/foods", middleware.Authorize(models.ROLE_MANAGER), controller.CreateFood())
+	incomingRoutes.PATCH("/foods/:food_id", middleware.Authorize(models.ROLE_MANAGER), controller.UpdateFood())
+	incomingRoutes.DELETE("/foods/:food_id", middleware.Authorize(models.ROLE_MANAGER), controller.DeleteFood())
 
 }
@@ -10,5 +10,6 @@ import (
 func HomeRoutes(incomingRoutes *gin.Engine) {
 	incomingRoutes.GET("/", controller.Home())
 	incomingRoutes.GET("/docs", controller.Documentation())
+	incomingRoutes.GET("/.well-known/jwks.json", controller.JWKS())
 
 }
@@ -2,14 +2,16 @@ package routes
 
 import (
 	controller "golang-restaurant-management/controllers"
+	"golang-restaurant-management/middleware"
+	"golang-restaurant-management/models"
 
 	"github.com/gin-gonic/gin"
 )
 
 func InvoiceRoutes(incomingRoutes *gin.Engine) {
-	incomingRoutes.GET("/invoices", controller.GetInvoices())
-	incomingRoutes.GET("/invoices/:invoice_id", controller.GetInvoice())
-	incomingRoutes.POST("/invoices", controller.CreateInvoice())
-	incomingRoutes.PATCH("/invoices/:invoice_id", controller.UpdateInvoice())
-	incomingRoutes.DELETE("/invoices/:invoice_id", controller.DeleteInvoice())
+	incomingRoutes.GET("/invoices", middleware.Authorize(models.ROLE_MANAGER, models.ROLE_CASHIER), controller.GetInvoices())
+	incomingRoutes.GET("/invoices/:invoice_id", middleware.Authorize(models.ROLE_MANAGER, models.ROLE_CASHIER, models.ROLE_WAITER), controller.GetInvoice())
+	incomingRoutes.POST("/invoices", middleware.Authorize(models.ROLE_MANAGER, models.ROLE_CASHIER), middleware.Idempotency(), controller.CreateInvoice())
+	incomingRoutes.PATCH("/invoices/:invoice_id", middleware.Authorize(models.ROLE_CASHIER), controller.UpdateInvoice())
+	incomingRoutes.DELETE("/invoices/:invoice_id", middleware.Authorize(models.ROLE_MANAGER), controller.DeleteInvoice())
 }
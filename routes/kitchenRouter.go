@@ -0,0 +1,15 @@
+package routes
+
+import (
+	controller "golang-restaurant-management/controllers"
+	"golang-restaurant-management/middleware"
+	"golang-restaurant-management/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func KitchenRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.POST("/kitchen/feed/tickets", middleware.Authentication(), middleware.Authorize(models.ROLE_MANAGER, models.ROLE_KITCHEN, models.ROLE_WAITER), controller.CreateKitchenFeedTicket())
+	incomingRoutes.GET("/kitchen/feed", middleware.FeedAuthentication(), middleware.Authorize(models.ROLE_MANAGER, models.ROLE_KITCHEN, models.ROLE_WAITER), controller.KitchenFeed())
+	incomingRoutes.GET("/kitchen/feed/ws", middleware.FeedAuthentication(), middleware.Authorize(models.ROLE_MANAGER, models.ROLE_KITCHEN, models.ROLE_WAITER), controller.KitchenFeedSocket())
+}
@@ -2,6 +2,8 @@ package routes
 
 import (
 	controller "golang-restaurant-management/controllers"
+	"golang-restaurant-management/middleware"
+	"golang-restaurant-management/models"
 
 	"github.com/gin-gonic/gin"
 )
@@ -9,7 +11,7 @@ import (
 func MenuRoutes(incomingRoutes *gin.Engine) {
 	incomingRoutes.GET("/menus", controller.GetMenus())
 	incomingRoutes.GET("/menus/:menu_id", controller.GetMenu())
-	incomingRoutes.POST("/menus", controller.CreateMenu())
-	incomingRoutes.PATCH("/menus/:menu_id", controller.UpdateMenu())
-	incomingRoutes.DELETE("/menus/:menu_id", controller.DeleteMenu())
+	incomingRoutes.POST("/menus", middleware.Authorize(models.ROLE_MANAGER), controller.CreateMenu())
+	incomingRoutes.PATCH("/menus/:menu_id", middleware.Authorize(models.ROLE_MANAGER), controller.UpdateMenu())
+	incomingRoutes.DELETE("/menus/:menu_id", middleware.Authorize(models.ROLE_MANAGER), controller.DeleteMenu())
 }
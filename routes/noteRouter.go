@@ -0,0 +1,17 @@
+package routes
+
+import (
+	controller "golang-restaurant-management/controllers"
+	"golang-restaurant-management/middleware"
+	"golang-restaurant-management/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func NoteRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/notes", middleware.Authorize(models.ROLE_MANAGER, models.ROLE_WAITER, models.ROLE_KITCHEN, models.ROLE_CASHIER), controller.GetNotes())
+	incomingRoutes.GET("/notes/:note_id", middleware.Authorize(models.ROLE_MANAGER, models.ROLE_WAITER, models.ROLE_KITCHEN, models.ROLE_CASHIER), controller.GetNote())
+	incomingRoutes.POST("/notes", middleware.Authorize(models.ROLE_MANAGER, models.ROLE_WAITER, models.ROLE_KITCHEN, models.ROLE_CASHIER), controller.CreateNote())
+	incomingRoutes.PATCH("/notes/:note_id", middleware.Authorize(models.ROLE_MANAGER, models.ROLE_WAITER, models.ROLE_KITCHEN, models.ROLE_CASHIER), controller.UpdateNote())
+	incomingRoutes.DELETE("/notes/:note_id", middleware.Authorize(models.ROLE_MANAGER, models.ROLE_WAITER, models.ROLE_KITCHEN, models.ROLE_CASHIER), controller.DeleteNote())
+}
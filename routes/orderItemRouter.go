@@ -2,6 +2,8 @@ package routes
 
 import (
 	controller "golang-restaurant-management/controllers"
+	"golang-restaurant-management/middleware"
+	"golang-restaurant-management/models"
 
 	"github.com/gin-gonic/gin"
 )
@@ -10,7 +12,7 @@ func OrderItemRoutes(incomingRoutes *gin.Engine) {
 	incomingRoutes.GET("/orderItems", controller.GetOrderItems())
 	incomingRoutes.GET("/orderItems/:order_item_id", controller.GetOrderItem())
 	incomingRoutes.GET("/orders/:order_id/items", controller.GetOrderItemsByOrder())
-	incomingRoutes.POST("/orderItems", controller.CreateOrderItem())
-	incomingRoutes.PATCH("/orderItems/:orderItem_id", controller.UpdateOrderItem())
-	incomingRoutes.DELETE("/orderItems/:orderItem_id", controller.DeleteOrderItem())
+	incomingRoutes.POST("/orderItems", middleware.Authorize(models.ROLE_MANAGER, models.ROLE_WAITER, models.ROLE_CASHIER), middleware.Idempotency(), controller.CreateOrderItem())
+	incomingRoutes.PATCH("/orderItems/:orderItem_id", middleware.Authorize(models.ROLE_MANAGER, models.ROLE_WAITER, models.ROLE_KITCHEN), controller.UpdateOrderItem())
+	incomingRoutes.POST("/orderItems/:order_item_id/void", middleware.Authorize(models.ROLE_MANAGER, models.ROLE_WAITER, models.ROLE_CASHIER), controller.VoidOrderItem())
 }
@@ -2,6 +2,8 @@ package routes
 
 import (
 	controller "golang-restaurant-management/controllers"
+	"golang-restaurant-management/middleware"
+	"golang-restaurant-management/models"
 
 	"github.com/gin-gonic/gin"
 )
@@ -9,7 +11,11 @@ import (
 func OrderRoutes(incomingRoutes *gin.Engine) {
 	incomingRoutes.GET("/orders", controller.GetOrders())
 	incomingRoutes.GET("/orders/:order_id", controller.GetOrder())
-	incomingRoutes.POST("/orders", controller.CreateOrder())
-	incomingRoutes.PATCH("/orders/:order_id", controller.UpdateOrder())
-	incomingRoutes.DELETE("/orders/:order_id", controller.DeleteOrder())
+	incomingRoutes.POST("/orders", middleware.Authorize(models.ROLE_MANAGER, models.ROLE_WAITER, models.ROLE_CASHIER), middleware.Idempotency(), controller.CreateOrder())
+	incomingRoutes.PATCH("/orders/:order_id", middleware.Authorize(models.ROLE_MANAGER, models.ROLE_WAITER), controller.UpdateOrder())
+	incomingRoutes.POST("/orders/:order_id/transitions", middleware.Authorize(models.ROLE_MANAGER, models.ROLE_WAITER, models.ROLE_KITCHEN, models.ROLE_CASHIER), controller.TransitionOrder())
+	incomingRoutes.POST("/orders/:order_id/cancel", middleware.Authorize(models.ROLE_MANAGER, models.ROLE_WAITER), controller.CancelOrder())
+	incomingRoutes.POST("/orders/:order_id/transfer", middleware.Authorize(models.ROLE_MANAGER, models.ROLE_WAITER, models.ROLE_CASHIER), controller.TransferOrder())
+	incomingRoutes.POST("/orders/:order_id/merge", middleware.Authorize(models.ROLE_MANAGER, models.ROLE_WAITER, models.ROLE_CASHIER), controller.MergeOrders())
+	incomingRoutes.POST("/orders/:order_id/split", middleware.Authorize(models.ROLE_MANAGER, models.ROLE_WAITER, models.ROLE_CASHIER), controller.SplitOrder())
 }
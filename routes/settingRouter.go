@@ -0,0 +1,16 @@
+package routes
+
+import (
+	controller "golang-restaurant-management/controllers"
+	"golang-restaurant-management/middleware"
+	"golang-restaurant-management/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func SettingRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/settings/two-factor", middleware.Authorize(models.ROLE_ADMIN), controller.GetTwoFactorPolicy())
+	incomingRoutes.PUT("/settings/two-factor", middleware.Authorize(models.ROLE_ADMIN), controller.UpdateTwoFactorPolicy())
+	incomingRoutes.GET("/settings/void-policy", middleware.Authorize(models.ROLE_ADMIN), controller.GetVoidPolicy())
+	incomingRoutes.PUT("/settings/void-policy", middleware.Authorize(models.ROLE_ADMIN), controller.UpdateVoidPolicy())
+}
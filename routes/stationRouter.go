@@ -0,0 +1,19 @@
+package routes
+
+import (
+	controller "golang-restaurant-management/controllers"
+	"golang-restaurant-management/middleware"
+	"golang-restaurant-management/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func StationRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/stations", controller.GetStations())
+	incomingRoutes.GET("/stations/:station_id", controller.GetStation())
+	incomingRoutes.POST("/stations", middleware.Authorize(models.ROLE_MANAGER), controller.CreateStation())
+	incomingRoutes.PATCH("/stations/:station_id", middleware.Authorize(models.ROLE_MANAGER), controller.UpdateStation())
+	incomingRoutes.DELETE("/stations/:station_id", middleware.Authorize(models.ROLE_MANAGER), controller.DeleteStation())
+	incomingRoutes.GET("/stations/:station_id/items", middleware.Authorize(models.ROLE_MANAGER, models.ROLE_KITCHEN), controller.GetStationItems())
+	incomingRoutes.POST("/stations/:station_id/items/:order_item_id/bump", middleware.Authorize(models.ROLE_MANAGER, models.ROLE_KITCHEN), controller.BumpStationItem())
+}
@@ -2,6 +2,8 @@ package routes
 
 import (
 	controller "golang-restaurant-management/controllers"
+	"golang-restaurant-management/middleware"
+	"golang-restaurant-management/models"
 
 	"github.com/gin-gonic/gin"
 )
@@ -9,7 +11,7 @@ import (
 func TableRoutes(incomingRoutes *gin.Engine) {
 	incomingRoutes.GET("/tables", controller.GetTables())
 	incomingRoutes.GET("/tables/:table_id", controller.GetTable())
-	incomingRoutes.POST("/tables", controller.CreateTable())
-	incomingRoutes.PATCH("/tables/:table_id", controller.UpdateTable())
-	incomingRoutes.DELETE("/tables/:table_id", controller.DeleteTable())
+	incomingRoutes.POST("/tables", middleware.Authorize(models.ROLE_MANAGER), controller.CreateTable())
+	incomingRoutes.PATCH("/tables/:table_id", middleware.Authorize(models.ROLE_MANAGER, models.ROLE_WAITER), controller.UpdateTable())
+	incomingRoutes.DELETE("/tables/:table_id", middleware.Authorize(models.ROLE_MANAGER), controller.DeleteTable())
 }
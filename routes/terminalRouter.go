@@ -0,0 +1,14 @@
+package routes
+
+import (
+	controller "golang-restaurant-management/controllers"
+	"golang-restaurant-management/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TerminalRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/terminals/:terminal_id/staff", middleware.TerminalAuthentication(), controller.GetTerminalStaff())
+	incomingRoutes.POST("/terminals/:terminal_id/pin-login", middleware.TerminalAuthentication(), controller.PinLogin())
+	incomingRoutes.POST("/terminals/:terminal_id/lock", middleware.Authentication(), controller.LockTerminal())
+}
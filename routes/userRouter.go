@@ -2,16 +2,46 @@ package routes
 
 import (
 	controller "golang-restaurant-management/controllers"
+	"golang-restaurant-management/middleware"
+	"golang-restaurant-management/models"
 
 	"github.com/gin-gonic/gin"
 )
 
 func UserRoutes(incomingRoutes *gin.Engine) {
-	incomingRoutes.GET("/users", controller.GetUsers())
-	incomingRoutes.GET("/users/:user_id", controller.GetUser())
+	incomingRoutes.GET("/users", middleware.Authentication(), middleware.Authorize(models.ROLE_MANAGER), controller.GetUsers())
+	incomingRoutes.GET("/users/:user_id", middleware.Authentication(), middleware.Authorize(models.ROLE_MANAGER), controller.GetUser())
+	incomingRoutes.PATCH("/users/:user_id/role", middleware.Authentication(), middleware.Authorize(models.ROLE_ADMIN), controller.AssignRole())
+	incomingRoutes.POST("/users/:user_id/deactivate", middleware.Authentication(), middleware.Authorize(models.ROLE_ADMIN), controller.DeactivateUser())
+	incomingRoutes.POST("/users/:user_id/reactivate", middleware.Authentication(), middleware.Authorize(models.ROLE_ADMIN), controller.ReactivateUser())
+	incomingRoutes.GET("/users/:user_id/export", middleware.Authentication(), controller.ExportUserData())
+	incomingRoutes.POST("/users/:user_id/erase", middleware.Authentication(), middleware.Authorize(models.ROLE_ADMIN), controller.EraseUser())
 	incomingRoutes.POST("/users/signup", controller.SignUp())
+	incomingRoutes.GET("/users/invitations", middleware.Authentication(), middleware.Authorize(models.ROLE_ADMIN), controller.GetInvitations())
+	incomingRoutes.POST("/users/invitations", middleware.Authentication(), middleware.Authorize(models.ROLE_ADMIN), controller.CreateInvitation())
+	incomingRoutes.DELETE("/users/invitations/:invitation_id", middleware.Authentication(), middleware.Authorize(models.ROLE_ADMIN), controller.RevokeInvitation())
+	incomingRoutes.POST("/users/invitations/accept", controller.AcceptInvitation())
 	incomingRoutes.POST("/users/login", controller.Login())
+	incomingRoutes.POST("/users/login/2fa", controller.LoginTwoFactor())
+	incomingRoutes.GET("/users/oidc/login", controller.OIDCLogin())
+	incomingRoutes.GET("/users/oidc/callback", controller.OIDCCallback())
+	incomingRoutes.POST("/users/me/2fa/enroll", middleware.EnrollmentAuthentication(), controller.EnrollTwoFactor())
+	incomingRoutes.POST("/users/me/2fa/confirm", middleware.EnrollmentAuthentication(), controller.ConfirmTwoFactor())
+	incomingRoutes.POST("/users/me/2fa/disable", middleware.Authentication(), controller.DisableTwoFactor())
+	incomingRoutes.DELETE("/users/:user_id/2fa", middleware.Authentication(), middleware.Authorize(models.ROLE_ADMIN), controller.ResetTwoFactor())
+	incomingRoutes.GET("/users/me", middleware.Authentication(), controller.GetMe())
+	incomingRoutes.PATCH("/users/me", middleware.Authentication(), controller.UpdateMe())
+	incomingRoutes.POST("/users/me/password", middleware.Authentication(), controller.ChangePassword())
+	incomingRoutes.POST("/users/me/email", middleware.Authentication(), controller.RequestEmailChange())
+	incomingRoutes.POST("/users/me/email/confirm", middleware.Authentication(), controller.ConfirmEmailChange())
+	incomingRoutes.POST("/users/me/pin", middleware.Authentication(), controller.SetPin())
+	incomingRoutes.POST("/users/refresh", controller.RefreshToken())
+	incomingRoutes.POST("/users/logout", middleware.Authentication(), controller.Logout())
+	incomingRoutes.GET("/users/me/sessions", middleware.Authentication(), controller.GetMySessions())
+	incomingRoutes.DELETE("/users/me/sessions/:session_id", middleware.Authentication(), controller.DeleteMySession())
+	incomingRoutes.POST("/users/:user_id/sessions/revoke-all", middleware.Authentication(), controller.RevokeAllSessions())
 	incomingRoutes.POST("/users/verify-otp", controller.VerifyOTP())
+	incomingRoutes.POST("/users/resend-otp", controller.ResendOTP())
 	incomingRoutes.POST("/users/forgot-password", controller.ForgotPassword())
 	incomingRoutes.POST("/users/reset-password", controller.ResetPassword())
 
@@ -4,6 +4,7 @@ package tasks
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"html/template"
 	"log"
 	"os"
@@ -21,17 +22,55 @@ func init() {
 	})
 }
 
+// Types of the emails sent through the queue. Tasks queued without a type are
+// verification emails.
+const (
+	EMAIL_VERIFICATION   = "verification"
+	EMAIL_RESET_PASSWORD = "reset_password"
+	EMAIL_CHANGE         = "email_change"
+	EMAIL_INVITATION     = "invitation"
+)
+
+type emailTemplate struct {
+	file    string
+	subject string
+}
+
+var emailTemplates = map[string]emailTemplate{
+	EMAIL_VERIFICATION:   {file: "templates/email_template.html", subject: "Account Verification"},
+	EMAIL_RESET_PASSWORD: {file: "templates/reset_password_template.html", subject: "Reset Password OTP"},
+	EMAIL_CHANGE:         {file: "templates/email_change_template.html", subject: "Confirm Your New Email"},
+	EMAIL_INVITATION:     {file: "templates/invitation_template.html", subject: "You Are Invited to NeoEats"},
+}
+
 type EmailTask struct {
+	Type  string `json:"type"`
 	Email string `json:"email"`
 	OTP   string `json:"otp"`
+	Link  string `json:"link,omitempty"`
+	Role  string `json:"role,omitempty"`
 }
 
 func QueueVerificationEmail(email, otp string) error {
-	task := EmailTask{
-		Email: email,
-		OTP:   otp,
-	}
+	return queueEmail(EmailTask{Type: EMAIL_VERIFICATION, Email: email, OTP: otp})
+}
+
+func QueueResetPasswordEmail(email, otp string) error {
+	return queueEmail(EmailTask{Type: EMAIL_RESET_PASSWORD, Email: email, OTP: otp})
+}
 
+// QueueEmailChangeEmail sends the OTP confirming an email change to the new
+// address.
+func QueueEmailChangeEmail(email, otp string) error {
+	return queueEmail(EmailTask{Type: EMAIL_CHANGE, Email: email, OTP: otp})
+}
+
+// QueueInvitationEmail sends a staff invitation with the link to accept it.
+func QueueInvitationEmail(email, role, link string) error {
+	return queueEmail(EmailTask{Type: EMAIL_INVITATION, Email: email, Role: role, Link: link})
+}
+
+func queueEmail(task EmailTask) error {
 	jsonTask, err := json.Marshal(task)
 	if err != nil {
 		return err
@@ -42,7 +81,7 @@ func QueueVerificationEmail(email, otp string) error {
 		return err
 	}
 
-	log.Printf("Verification email for %s queued", email)
+	log.Printf("%s email for %s queued", task.Type, task.Email)
 	return nil
 }
 
@@ -61,7 +100,7 @@ func ProcessEmailQueue() {
 			continue
 		}
 
-		err = sendEmail(task.Email, task.OTP)
+		err = sendEmail(task)
 		if err != nil {
 			log.Printf("Error sending email: %v", err)
 			// Optionally, you could re-queue the task or implement a retry mechanism
@@ -69,55 +108,30 @@ func ProcessEmailQueue() {
 	}
 }
 
-func sendEmail(email, otp string) error {
-	// Load email template
-	tmpl, err := template.ParseFiles("templates/email_template.html")
-	if err != nil {
-		return err
-	}
-
-	var body bytes.Buffer
-	if err := tmpl.Execute(&body, struct{ OTP string }{OTP: otp}); err != nil {
-		return err
+func sendEmail(task EmailTask) error {
+	if task.Type == "" {
+		task.Type = EMAIL_VERIFICATION
 	}
-
-	m := gomail.NewMessage()
-	m.SetHeader("From", "Restaurant System <"+os.Getenv("SMTP_EMAIL")+">")
-	m.SetHeader("To", email)
-	m.SetHeader("Subject", "Account Verification")
-	m.SetBody("text/html", body.String())
-
-	smtpPort, err := strconv.Atoi(os.Getenv("SMTP_PORT"))
-	if err != nil {
-		return err
+	emailTmpl, ok := emailTemplates[task.Type]
+	if !ok {
+		return fmt.Errorf("unknown email type %q", task.Type)
 	}
 
-	d := gomail.NewDialer(
-		os.Getenv("SMTP_HOST"),
-		smtpPort,
-		os.Getenv("SMTP_EMAIL"),
-		os.Getenv("SMTP_PASSWORD"),
-	)
-
-	return d.DialAndSend(m)
-}
-
-func QueueResetPasswordEmail(email, otp string) error {
 	// Load email template
-	tmpl, err := template.ParseFiles("templates/reset_password_template.html")
+	tmpl, err := template.ParseFiles(emailTmpl.file)
 	if err != nil {
 		return err
 	}
 
 	var body bytes.Buffer
-	if err := tmpl.Execute(&body, struct{ OTP string }{OTP: otp}); err != nil {
+	if err := tmpl.Execute(&body, task); err != nil {
 		return err
 	}
 
 	m := gomail.NewMessage()
 	m.SetHeader("From", "Restaurant System <"+os.Getenv("SMTP_EMAIL")+">")
-	m.SetHeader("To", email)
-	m.SetHeader("Subject", "Reset Password OTP")
+	m.SetHeader("To", task.Email)
+	m.SetHeader("Subject", emailTmpl.subject)
 	m.SetBody("text/html", body.String())
 
 	smtpPort, err := strconv.Atoi(os.Getenv("SMTP_PORT"))
@@ -133,7 +147,6 @@ func QueueResetPasswordEmail(email, otp string) error {
 	)
 
 	return d.DialAndSend(m)
-
 }
 
 // func QueueResetPasswordEmail(email, otp string) error {
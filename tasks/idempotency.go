@@ -0,0 +1,70 @@
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// IdempotentResponse is what is kept for an idempotency key. It is stored
+// without a status while the first request is still being handled.
+type IdempotentResponse struct {
+	Body_hash    string `json:"body_hash"`
+	Status       int    `json:"status"`
+	Content_type string `json:"content_type"`
+	Body         []byte `json:"body"`
+}
+
+func (response IdempotentResponse) Completed() bool {
+	return response.Status != 0
+}
+
+// ReserveIdempotencyKey claims key for a request whose body hashes to
+// bodyHash. It returns true when the key was free, otherwise the response kept
+// for the key by an earlier request. The claim lapses after lockTimeout if no
+// response is saved, e.g. because the handler crashed.
+func ReserveIdempotencyKey(key string, bodyHash string, lockTimeout time.Duration) (bool, IdempotentResponse, error) {
+	ctx := context.Background()
+
+	placeholder, err := json.Marshal(IdempotentResponse{Body_hash: bodyHash})
+	if err != nil {
+		return false, IdempotentResponse{}, err
+	}
+
+	reserved, err := RedisClient.SetNX(ctx, "idempotency:"+key, placeholder, lockTimeout).Result()
+	if err != nil || reserved {
+		return reserved, IdempotentResponse{}, err
+	}
+
+	raw, err := RedisClient.Get(ctx, "idempotency:"+key).Bytes()
+	if err == redis.Nil {
+		// The key expired in between, try once more.
+		reserved, err = RedisClient.SetNX(ctx, "idempotency:"+key, placeholder, lockTimeout).Result()
+		return reserved, IdempotentResponse{Body_hash: bodyHash}, err
+	}
+	if err != nil {
+		return false, IdempotentResponse{}, err
+	}
+
+	var stored IdempotentResponse
+	err = json.Unmarshal(raw, &stored)
+	return false, stored, err
+}
+
+func SaveIdempotentResponse(key string, response IdempotentResponse, window time.Duration) error {
+	ctx := context.Background()
+
+	payload, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+	return RedisClient.Set(ctx, "idempotency:"+key, payload, window).Err()
+}
+
+// ReleaseIdempotencyKey forgets key so the request can be retried.
+func ReleaseIdempotencyKey(key string) error {
+	ctx := context.Background()
+	return RedisClient.Del(ctx, "idempotency:"+key).Err()
+}
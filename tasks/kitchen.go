@@ -0,0 +1,204 @@
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Kitchen events are appended to a capped stream so a display that lost its
+// connection can replay what it missed, and published on a channel so every
+// API instance can push them to its own connected displays.
+const (
+	KITCHEN_STREAM        = "kitchen_events"
+	KITCHEN_CHANNEL       = "kitchen_events"
+	KITCHEN_STREAM_MAXLEN = 10000
+)
+
+const (
+	KITCHEN_ORDER_ITEM_CREATED      = "order_item.created"
+	KITCHEN_ORDER_ITEM_CANCELLED    = "order_item.cancelled"
+	KITCHEN_ORDER_ITEM_PREP_CHANGED = "order_item.prep_status_changed"
+	KITCHEN_ORDER_STATUS_CHANGED    = "order.status_changed"
+	KITCHEN_ORDER_CANCELLED         = "order.cancelled"
+	KITCHEN_ORDER_TRANSFERRED       = "order.transferred"
+	KITCHEN_NOTE_CREATED            = "note.created"
+	KITCHEN_NOTE_UPDATED            = "note.updated"
+	KITCHEN_NOTE_DELETED            = "note.deleted"
+)
+
+// KitchenEvent is one ticket update. Id is the stream id of the event and
+// doubles as the cursor to resume from. Events without a Station concern
+// every station, Visibility is only set on note events.
+type KitchenEvent struct {
+	Id         string      `json:"id"`
+	Type       string      `json:"type"`
+	Order_id   string      `json:"order_id"`
+	Order_type string      `json:"order_type,omitempty"`
+	Table_id   string      `json:"table_id,omitempty"`
+	Station    string      `json:"station,omitempty"`
+	Status     string      `json:"status,omitempty"`
+	Reason     *string     `json:"reason,omitempty"`
+	Visibility string      `json:"visibility,omitempty"`
+	Order_item interface{} `json:"order_item,omitempty"`
+	Note       interface{} `json:"note,omitempty"`
+	Created_at time.Time   `json:"created_at"`
+}
+
+// PublishKitchenEvent stores the event in the stream and then announces it
+// on the channel with its stream id set.
+func PublishKitchenEvent(event KitchenEvent) error {
+	ctx := context.Background()
+
+	if event.Created_at.IsZero() {
+		event.Created_at = time.Now()
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	id, err := RedisClient.XAdd(ctx, &redis.XAddArgs{
+		Stream: KITCHEN_STREAM,
+		MaxLen: KITCHEN_STREAM_MAXLEN,
+		Approx: true,
+		Values: map[string]interface{}{"event": payload},
+	}).Result()
+	if err != nil {
+		return err
+	}
+
+	event.Id = id
+	payload, err = json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	return RedisClient.Publish(ctx, KITCHEN_CHANNEL, payload).Err()
+}
+
+// KitchenEventsSince returns up to count events stored after cursor, oldest
+// first. Events older than the cap of the stream are gone.
+func KitchenEventsSince(ctx context.Context, cursor string, count int64) ([]KitchenEvent, error) {
+	streams, err := RedisClient.XRead(ctx, &redis.XReadArgs{
+		Streams: []string{KITCHEN_STREAM, cursor},
+		Count:   count,
+		Block:   -1,
+	}).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var events []KitchenEvent
+	for _, stream := range streams {
+		for _, message := range stream.Messages {
+			raw, _ := message.Values["event"].(string)
+
+			var event KitchenEvent
+			if err := json.Unmarshal([]byte(raw), &event); err != nil {
+				continue
+			}
+			event.Id = message.ID
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+// SubscribeKitchenEvents subscribes to the live events. The subscription is
+// confirmed before it is returned so nothing published afterwards is lost.
+func SubscribeKitchenEvents(ctx context.Context) (*redis.PubSub, error) {
+	pubsub := RedisClient.Subscribe(ctx, KITCHEN_CHANNEL)
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return nil, err
+	}
+	return pubsub, nil
+}
+
+// ValidKitchenCursor reports whether cursor looks like a stream id.
+func ValidKitchenCursor(cursor string) bool {
+	_, _, ok := parseStreamID(cursor)
+	return ok
+}
+
+// KitchenEventAfter reports whether the stream id id comes after cursor.
+func KitchenEventAfter(id string, cursor string) bool {
+	idMs, idSeq, ok := parseStreamID(id)
+	if !ok {
+		return false
+	}
+	cursorMs, cursorSeq, ok := parseStreamID(cursor)
+	if !ok {
+		return true
+	}
+	return idMs > cursorMs || (idMs == cursorMs && idSeq > cursorSeq)
+}
+
+func parseStreamID(id string) (uint64, uint64, bool) {
+	parts := strings.SplitN(id, "-", 2)
+
+	ms, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	if len(parts) == 1 {
+		return ms, 0, true
+	}
+
+	seq, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return ms, seq, true
+}
+
+// How long a feed ticket can be redeemed, displays open the feed right after
+// asking for one.
+const KITCHEN_TICKET_TTL = 30 * time.Second
+
+var ErrKitchenTicketNotFound = errors.New("feed ticket not found or expired")
+
+// KitchenCredential is what a feed ticket stands for, the access token or
+// the api key it was issued to. Open feeds check it again while they run.
+type KitchenCredential struct {
+	Token   string `json:"token,omitempty"`
+	Api_key string `json:"api_key,omitempty"`
+}
+
+func StoreKitchenTicket(ticket string, credential KitchenCredential) error {
+	jsonData, err := json.Marshal(credential)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	return RedisClient.Set(ctx, "kitchen_ticket:"+ticket, jsonData, KITCHEN_TICKET_TTL).Err()
+}
+
+// TakeKitchenTicket returns the credential of a ticket and deletes it, every
+// ticket opens one feed.
+func TakeKitchenTicket(ticket string) (KitchenCredential, error) {
+	var credential KitchenCredential
+
+	ctx := context.Background()
+	jsonData, err := RedisClient.GetDel(ctx, "kitchen_ticket:"+ticket).Bytes()
+	if err == redis.Nil {
+		return credential, ErrKitchenTicketNotFound
+	}
+	if err != nil {
+		return credential, err
+	}
+
+	err = json.Unmarshal(jsonData, &credential)
+	return credential, err
+}
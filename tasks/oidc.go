@@ -0,0 +1,50 @@
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// How long a user has to finish logging in at the identity provider.
+const OIDC_STATE_TTL = 10 * time.Minute
+
+var ErrOIDCStateNotFound = errors.New("login state not found or expired")
+
+// OIDCState is kept between redirecting to the identity provider and its
+// callback.
+type OIDCState struct {
+	Nonce         string `json:"nonce"`
+	Code_verifier string `json:"code_verifier"`
+}
+
+func StoreOIDCState(state string, data OIDCState) error {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	return RedisClient.Set(ctx, "oidc_state:"+state, jsonData, OIDC_STATE_TTL).Err()
+}
+
+// TakeOIDCState returns the data stored for state and deletes it, so every
+// state can complete a login only once.
+func TakeOIDCState(state string) (OIDCState, error) {
+	var data OIDCState
+
+	ctx := context.Background()
+	jsonData, err := RedisClient.GetDel(ctx, "oidc_state:"+state).Bytes()
+	if err == redis.Nil {
+		return data, ErrOIDCStateNotFound
+	}
+	if err != nil {
+		return data, err
+	}
+
+	err = json.Unmarshal(jsonData, &data)
+	return data, err
+}
@@ -2,14 +2,55 @@ package tasks
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"math/rand"
+	"math/big"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/go-redis/redis/v8"
 )
 
+const (
+	OTP_PURPOSE_VERIFY       = "verify"
+	OTP_PURPOSE_RESET        = "reset"
+	OTP_PURPOSE_EMAIL_CHANGE = "email-change"
+)
+
+const (
+	OTP_TTL             = 15 * time.Minute
+	OTP_RESEND_COOLDOWN = time.Minute
+)
+
+// MAX_OTP_GUESSES is how many wrong guesses an OTP survives before it is
+// thrown away and a new one has to be requested.
+const MAX_OTP_GUESSES = 5
+
+var ErrOTPNotFound = errors.New("OTP not found or expired")
+
+// otpSecret keys the OTP hashes, see LoadOTPKey.
+var otpSecret []byte
+
+// LoadOTPKey reads the key OTPs are hashed with from OTP_SECRET, falling back
+// to SECRET_KEY. Without either a stolen Redis dump would be enough to find
+// every pending OTP, so it refuses to start.
+func LoadOTPKey() error {
+	secret := os.Getenv("OTP_SECRET")
+	if secret == "" {
+		secret = os.Getenv("SECRET_KEY")
+	}
+	if secret == "" {
+		return errors.New("neither OTP_SECRET nor SECRET_KEY is set")
+	}
+	otpSecret = []byte(secret)
+	return nil
+}
+
 var redisClient *redis.Client
 
 func init() {
@@ -18,41 +59,128 @@ func init() {
 	})
 }
 
-func GenerateOTP(email string) (string, error) {
-	otp := fmt.Sprintf("%06d", rand.Intn(1000000))
+// otpKey namespaces the OTP by purpose so that a code sent for one flow can
+// never be used in another.
+func otpKey(purpose, subject string) string {
+	return "otp:" + purpose + ":" + strings.ToLower(subject)
+}
+
+func hashOTP(purpose, subject, otp string) string {
+	if len(otpSecret) == 0 {
+		panic("tasks: OTP key not loaded, call LoadOTPKey first")
+	}
+	mac := hmac.New(sha256.New, otpSecret)
+	mac.Write([]byte(otpKey(purpose, subject) + ":" + otp))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func GenerateOTP() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	otp := fmt.Sprintf("%06d", n.Int64())
 	return otp, nil
 }
 
-func StoreOTP(email, otp string) error {
+// StoreOTP keeps only a hash of the OTP, replacing any earlier OTP for the
+// same purpose and subject.
+func StoreOTP(purpose, subject, otp string) error {
 	ctx := context.Background()
-	err := redisClient.Set(ctx, email, otp, 15*time.Minute).Err()
+	key := otpKey(purpose, subject)
+	err := redisClient.Set(ctx, key, hashOTP(purpose, subject, otp), OTP_TTL).Err()
 	if err != nil {
 		return err
 	}
-	return nil
+	return redisClient.Del(ctx, key+":guesses").Err()
 }
 
-func GetStoredOTP(email string) (string, error) {
+// VerifyOTP checks the OTP and consumes it on success, so every OTP can be
+// used exactly once. It returns ErrOTPNotFound when there is no OTP to check.
+func VerifyOTP(purpose, subject, otp string) (bool, error) {
 	ctx := context.Background()
-	otp, err := redisClient.Get(ctx, email).Result()
+	key := otpKey(purpose, subject)
+
+	storedHash, err := redisClient.Get(ctx, key).Result()
 	if err != nil {
 		if err == redis.Nil {
-			return "", fmt.Errorf("OTP not found for email: %s", email)
+			return false, ErrOTPNotFound
 		}
-		return "", err
+		return false, err
 	}
-	return otp, nil
+
+	if !hmac.Equal([]byte(storedHash), []byte(hashOTP(purpose, subject, otp))) {
+		return false, nil
+	}
+
+	// Only the request that actually deletes the OTP gets to use it
+	deleted, err := redisClient.Del(ctx, key).Result()
+	if err != nil {
+		return false, err
+	}
+	if deleted == 0 {
+		return false, ErrOTPNotFound
+	}
+
+	redisClient.Del(ctx, key+":guesses")
+	return true, nil
 }
 
-func ClearStoredOTP(email string) error {
+// RegisterWrongOTP counts a wrong guess against the stored OTP and clears the
+// OTP once MAX_OTP_GUESSES is reached. It reports whether the OTP was cleared.
+func RegisterWrongOTP(purpose, subject string) (bool, error) {
 	ctx := context.Background()
-	err := redisClient.Del(ctx, email).Err()
+	guessesKey := otpKey(purpose, subject) + ":guesses"
+
+	count, err := redisClient.Incr(ctx, guessesKey).Result()
+	if err != nil {
+		return false, err
+	}
+	if count == 1 {
+		redisClient.Expire(ctx, guessesKey, OTP_TTL)
+	}
+
+	if count < MAX_OTP_GUESSES {
+		return false, nil
+	}
+	return true, ClearStoredOTP(purpose, subject)
+}
+
+func ClearStoredOTP(purpose, subject string) error {
+	ctx := context.Background()
+	key := otpKey(purpose, subject)
+	err := redisClient.Del(ctx, key, key+":guesses").Err()
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// StartOTPCooldown makes sure OTPs are not sent more often than once per
+// OTP_RESEND_COOLDOWN. It returns how long the caller still has to wait, or 0
+// when a new OTP may be sent now.
+func StartOTPCooldown(purpose, subject string) (time.Duration, error) {
+	ctx := context.Background()
+	key := "otp_cooldown:" + purpose + ":" + strings.ToLower(subject)
+
+	started, err := redisClient.SetNX(ctx, key, 1, OTP_RESEND_COOLDOWN).Result()
+	if err != nil {
+		return 0, err
+	}
+	if started {
+		return 0, nil
+	}
+
+	ttl, err := redisClient.PTTL(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+	if ttl < 0 {
+		return 0, nil
+	}
+	return ttl, nil
+}
+
 // // tasks/otp.go
 // package tasks
 
@@ -0,0 +1,78 @@
+package tasks
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	EMAIL_ATTEMPT_LIMIT = 5
+	IP_ATTEMPT_LIMIT    = 20
+
+	attemptWindow = time.Hour
+	lockoutBase   = 30 * time.Second
+	lockoutMax    = time.Hour
+)
+
+// CheckLockout returns how long the key is still locked out for, or 0 when
+// attempts are allowed.
+func CheckLockout(key string) (time.Duration, error) {
+	ctx := context.Background()
+	ttl, err := RedisClient.PTTL(ctx, "lockout:"+key).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return 0, nil
+		}
+		return 0, err
+	}
+	if ttl < 0 {
+		return 0, nil
+	}
+	return ttl, nil
+}
+
+// RegisterFailedAttempt counts a failed attempt for the key. Once maxAttempts
+// failures happened within the window the key is locked out, and the lockout
+// doubles with every further failure up to lockoutMax. It returns the lockout
+// that was applied, or 0 if the key is not locked yet.
+func RegisterFailedAttempt(key string, maxAttempts int) (time.Duration, error) {
+	ctx := context.Background()
+	attemptsKey := "attempts:" + key
+
+	count, err := RedisClient.Incr(ctx, attemptsKey).Result()
+	if err != nil {
+		return 0, err
+	}
+	if count == 1 {
+		RedisClient.Expire(ctx, attemptsKey, attemptWindow)
+	}
+
+	over := count - int64(maxAttempts)
+	if over < 0 {
+		return 0, nil
+	}
+
+	lockout := lockoutMax
+	if over < 10 {
+		lockout = lockoutBase << uint(over)
+		if lockout > lockoutMax {
+			lockout = lockoutMax
+		}
+	}
+
+	// Keep counting past the lockout so the next failure locks out longer
+	RedisClient.Expire(ctx, attemptsKey, lockout+attemptWindow)
+
+	err = RedisClient.Set(ctx, "lockout:"+key, 1, lockout).Err()
+	if err != nil {
+		return 0, err
+	}
+	return lockout, nil
+}
+
+func ResetAttempts(key string) error {
+	ctx := context.Background()
+	return RedisClient.Del(ctx, "attempts:"+key, "lockout:"+key).Err()
+}
@@ -0,0 +1,65 @@
+package tasks
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// RevokeToken puts the token id on the revocation list until the token
+// would have expired anyway.
+func RevokeToken(jti string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return nil
+	}
+	ctx := context.Background()
+	return RedisClient.Set(ctx, "revoked_token:"+jti, 1, ttl).Err()
+}
+
+func IsTokenRevoked(jti string) (bool, error) {
+	ctx := context.Background()
+	count, err := RedisClient.Exists(ctx, "revoked_token:"+jti).Result()
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// RevokeTokenFamily invalidates every token of one login, i.e. all tokens
+// that share the family claim.
+func RevokeTokenFamily(family string, ttl time.Duration) error {
+	ctx := context.Background()
+	return RedisClient.Set(ctx, "revoked_family:"+family, 1, ttl).Err()
+}
+
+func IsTokenFamilyRevoked(family string) (bool, error) {
+	ctx := context.Background()
+	count, err := RedisClient.Exists(ctx, "revoked_family:"+family).Result()
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// How long a token version is cached before it is read from Mongo again.
+const tokenVersionCacheTTL = time.Hour
+
+func CacheTokenVersion(userId string, version int) error {
+	ctx := context.Background()
+	return RedisClient.Set(ctx, "token_version:"+userId, version, tokenVersionCacheTTL).Err()
+}
+
+// CachedTokenVersion returns the cached token version of the user and whether
+// there was one.
+func CachedTokenVersion(userId string) (int, bool, error) {
+	ctx := context.Background()
+	version, err := RedisClient.Get(ctx, "token_version:"+userId).Int()
+	if err == redis.Nil {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return version, true, nil
+}
@@ -0,0 +1,26 @@
+package tasks
+
+import (
+	"context"
+	"time"
+)
+
+// StartTerminalActivity marks a PIN token as active. The mark expires after
+// idleTimeout unless TouchTerminalActivity refreshes it, which locks the
+// terminal again.
+func StartTerminalActivity(jti string, idleTimeout time.Duration) error {
+	ctx := context.Background()
+	return RedisClient.Set(ctx, "terminal_active:"+jti, 1, idleTimeout).Err()
+}
+
+// TouchTerminalActivity extends the activity mark of a PIN token. It returns
+// false when the mark already expired, i.e. the terminal is locked.
+func TouchTerminalActivity(jti string, idleTimeout time.Duration) (bool, error) {
+	ctx := context.Background()
+	return RedisClient.Expire(ctx, "terminal_active:"+jti, idleTimeout).Result()
+}
+
+func EndTerminalActivity(jti string) error {
+	ctx := context.Background()
+	return RedisClient.Del(ctx, "terminal_active:"+jti).Err()
+}